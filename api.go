@@ -1,27 +1,92 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // used for generating salt
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 type SubsonicConnection struct {
-	Username       string
-	Password       string
-	Host           string
-	PlaintextAuth  bool
+	Username      string
+	Password      string
+	Host          string
+	PlaintextAuth bool
+	ApiKey        string // OpenSubsonic API key, used instead of username and password
+	// pass stream credentials to the player as an HTTP basic auth header, see
+	// StreamHeaders, rather than in the stream url. Subsonic servers don't
+	// take basic auth, this is only for a reverse proxy in front of one that
+	// checks it. See CheckProxyBasicAuth.
+	ProxyBasicAuth bool
 	Scrobble       bool
 	Logger         Logger
-	directoryCache map[string]SubsonicResponse
+	CacheDir       string
+	Downloads      *Downloads     // songs kept for playing offline, may be nil
+	Scrobbles      *ScrobbleQueue // plays yet to be submitted, nil unless Scrobble
+	Client         *http.Client   // http.DefaultClient if nil
+	UserAgent      string
+	Retries        int           // extra attempts for failed reads
+	RetryDelay     time.Duration // wait before the first retry, doubled after each
+	// responses kept between runs, may be nil
+	Library *Library
+	// how often to look for the server while offline, see goOffline
+	ReconnectInterval time.Duration
+
+	// guards what follows, and ApiKey once Negotiate runs
+	lock sync.Mutex
+	// what the server supports, filled in by Negotiate
+	apiVersion string
+	extensions map[string][]int
+	negotiated bool
+	// see offline.go
+	offline         bool
+	closed          bool
+	onOfflineChange func(offline bool)
+}
+
+const defaultRetryDelay = 500 * time.Millisecond
+
+// the newest API version stmp speaks. Servers that are older get their own
+// version, see Negotiate.
+const clientApiVersion = "1.16.1"
+
+// OpenSubsonic extensions stmp knows how to use, see
+// https://opensubsonic.netlify.app/docs/extensions/
+const (
+	ExtensionApiKey     = "apiKeyAuthentication"
+	ExtensionFormPost   = "formPost"
+	ExtensionSongLyrics = "songLyrics"
+)
+
+// NewHTTPClient returns the client stmp talks to the server with. timeout
+// bounds a whole request, including reading the body; a stalled server fails
+// the request rather than hanging whoever made it.
+func NewHTTPClient(timeout time.Duration, keepAlive bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = !keepAlive
+	transport.MaxIdleConnsPerHost = 4
+	transport.ResponseHeaderTimeout = timeout
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
 }
 
 func randSeq(n int) string {
@@ -40,7 +105,16 @@ func authToken(password string) (string, string) {
 }
 
 func defaultQuery(connection *SubsonicConnection) url.Values {
-	query := url.Values{}
+	query := anonymousQuery(connection)
+	connection.lock.Lock()
+	apiKey := connection.ApiKey
+	connection.lock.Unlock()
+	if apiKey != "" {
+		// the username must not be sent along with an API key
+		query.Set("apiKey", apiKey)
+		return query
+	}
+
 	if connection.PlaintextAuth {
 		query.Set("p", connection.Password)
 	} else {
@@ -49,7 +123,21 @@ func defaultQuery(connection *SubsonicConnection) url.Values {
 		query.Set("s", salt)
 	}
 	query.Set("u", connection.Username)
-	query.Set("v", "1.15.1")
+
+	return query
+}
+
+// anonymousQuery is defaultQuery without credentials
+func anonymousQuery(connection *SubsonicConnection) url.Values {
+	connection.lock.Lock()
+	version := connection.apiVersion
+	connection.lock.Unlock()
+	if version == "" {
+		version = clientApiVersion
+	}
+
+	query := url.Values{}
+	query.Set("v", version)
 	query.Set("c", "stmp")
 	query.Set("f", "json")
 
@@ -57,15 +145,112 @@ func defaultQuery(connection *SubsonicConnection) url.Values {
 }
 
 // response structs
+
+// Errors a failed request can be matched against with errors.Is, whether it
+// failed with a subsonic error code or an HTTP status.
+var (
+	ErrAuthFailed      = errors.New("wrong username or password")
+	ErrUnauthorized    = errors.New("user is not authorized for the given operation")
+	ErrNotFound        = errors.New("the requested data was not found")
+	ErrVersionMismatch = errors.New("incompatible subsonic REST protocol version")
+)
+
+// SubsonicError is the error element of a response with status "failed"
 type SubsonicError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 }
 
+func (e *SubsonicError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("subsonic error %d", e.Code)
+	}
+	return fmt.Sprintf("%s (error %d)", e.Message, e.Code)
+}
+
+// Is maps subsonic error codes onto the Err* values, see
+// http://www.subsonic.org/pages/api.jsp and the OpenSubsonic additions
+func (e *SubsonicError) Is(target error) bool {
+	switch e.Code {
+	case 20, 30:
+		return target == ErrVersionMismatch
+	case 40, 41, 42, 43, 44:
+		return target == ErrAuthFailed
+	case 50:
+		return target == ErrUnauthorized
+	case 70:
+		return target == ErrNotFound
+	}
+	return false
+}
+
+// Rejected reports whether the server turned down the request itself, for a
+// missing parameter or an id it doesn't know, so sending it again won't help
+func (e *SubsonicError) Rejected() bool {
+	return e.Code == 10 || e.Code == 70
+}
+
+// HTTPStatusError is returned when the server answers with a non 2xx status
+// instead of a subsonic response
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return "unexpected HTTP status " + e.Status
+}
+
+func (e *HTTPStatusError) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return target == ErrAuthFailed
+	case http.StatusForbidden:
+		return target == ErrUnauthorized
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	}
+	return false
+}
+
+// Rejected reports whether the server found fault with what was sent, as
+// opposed to who sent it or where
+func (e *HTTPStatusError) Rejected() bool {
+	return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+}
+
+// describeError turns an error into a short message fit for the status line
+func describeError(err error) string {
+	switch {
+	case errors.Is(err, ErrAuthFailed):
+		return "authentication failed, check auth.username and auth.password, or auth.apiKey"
+	case errors.Is(err, ErrUnauthorized):
+		return "not authorized: " + err.Error()
+	case errors.Is(err, ErrNotFound):
+		return "not found: " + err.Error()
+	case errors.Is(err, ErrVersionMismatch):
+		return "server and client versions are incompatible: " + err.Error()
+	}
+	return err.Error()
+}
+
 type SubsonicArtist struct {
 	Id         string
 	Name       string
 	AlbumCount int
+	Albums     []SubsonicAlbum `json:"album"`
+}
+
+type SubsonicAlbum struct {
+	Id        string           `json:"id"`
+	Name      string           `json:"name"`
+	Artist    string           `json:"artist"`
+	ArtistId  string           `json:"artistId"`
+	CoverArt  string           `json:"coverArt"`
+	Year      int              `json:"year"`
+	SongCount int              `json:"songCount"`
+	Duration  int              `json:"duration"`
+	Songs     SubsonicEntities `json:"song"`
 }
 
 type SubsonicDirectory struct {
@@ -79,8 +264,20 @@ type SubsonicSongs struct {
 	Song SubsonicEntities `json:"song"`
 }
 
+// SubsonicStarredFolders is the part of the getStarred response stmp uses:
+// the artist and album folders starred by folder id, which getStarred2
+// doesn't list on servers whose folder ids differ from their ID3 ids
+type SubsonicStarredFolders struct {
+	Artists []SubsonicArtist `json:"artist"`
+	Albums  SubsonicEntities `json:"album"`
+}
+
+// SubsonicStarred is the getStarred2 response, which splits starred items by
+// kind.
 type SubsonicStarred struct {
-	Starred SubsonicEntities `json:"starred"`
+	Artists []SubsonicArtist `json:"artist"`
+	Albums  []SubsonicAlbum  `json:"album"`
+	Songs   SubsonicEntities `json:"song"`
 }
 
 type SubsonicEntity struct {
@@ -89,10 +286,17 @@ type SubsonicEntity struct {
 	Parent      string `json:"parent"`
 	Title       string `json:"title"`
 	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	AlbumId     string `json:"albumId"`
+	CoverArt    string `json:"coverArt"`
+	Year        int    `json:"year"`
+	BitRate     int    `json:"bitRate"`
+	Suffix      string `json:"suffix"`
 	Duration    int    `json:"duration"`
 	Track       int    `json:"track"`
 	DiskNumber  int    `json:"diskNumber"`
 	Path        string `json:"path"`
+	UserRating  int    `json:"userRating"`
 }
 
 // SubsonicEntities is a sortable list of entities.
@@ -119,7 +323,9 @@ func (s SubsonicEntities) Less(i, j int) bool {
 }
 
 type SubsonicIndexes struct {
-	Index []SubsonicIndex
+	// milliseconds since the epoch
+	LastModified int64 `json:"lastModified"`
+	Index        []SubsonicIndex
 }
 
 type SubsonicIndex struct {
@@ -134,20 +340,80 @@ type SubsonicPlaylists struct {
 type SubsonicPlaylist struct {
 	Id        SubsonicId       `json:"id"`
 	Name      string           `json:"name"`
+	Comment   string           `json:"comment"`
+	Owner     string           `json:"owner"`
+	Public    bool             `json:"public"`
 	SongCount int              `json:"songCount"`
+	Changed   string           `json:"changed"`
 	Entries   SubsonicEntities `json:"entry"`
 }
 
+// SubsonicArtistInfo is the getArtistInfo response. Biography is HTML, as
+// provided by last.fm.
+type SubsonicArtistInfo struct {
+	Biography      string           `json:"biography"`
+	MusicBrainzId  string           `json:"musicBrainzId"`
+	LastFmUrl      string           `json:"lastFmUrl"`
+	SimilarArtists []SubsonicArtist `json:"similarArtist"`
+}
+
+// SubsonicLyrics is the classic getLyrics response, plain text looked up by
+// artist and title
+type SubsonicLyrics struct {
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	Value  string `json:"value"`
+}
+
+// SubsonicExtension is an entry in the getOpenSubsonicExtensions response
+type SubsonicExtension struct {
+	Name     string `json:"name"`
+	Versions []int  `json:"versions"`
+}
+
+// SubsonicLyricsList is the OpenSubsonic getLyricsBySongId response
+type SubsonicLyricsList struct {
+	StructuredLyrics []SubsonicStructuredLyrics `json:"structuredLyrics"`
+}
+
+type SubsonicStructuredLyrics struct {
+	DisplayArtist string               `json:"displayArtist"`
+	DisplayTitle  string               `json:"displayTitle"`
+	Lang          string               `json:"lang"`
+	Offset        int                  `json:"offset"`
+	Synced        bool                 `json:"synced"`
+	Lines         []SubsonicLyricsLine `json:"line"`
+}
+
+// SubsonicLyricsLine is one line of lyrics. Start is in milliseconds and only
+// set for synced lyrics.
+type SubsonicLyricsLine struct {
+	Start int    `json:"start"`
+	Value string `json:"value"`
+}
+
 type SubsonicResponse struct {
-	Status      string            `json:"status"`
-	Version     string            `json:"version"`
-	Indexes     SubsonicIndexes   `json:"indexes"`
-	Directory   SubsonicDirectory `json:"directory"`
-	RandomSongs SubsonicSongs     `json:"randomSongs"`
-	Starred     SubsonicSongs     `json:"starred"`
-	Playlists   SubsonicPlaylists `json:"playlists"`
-	Playlist    SubsonicPlaylist  `json:"playlist"`
-	Error       SubsonicError     `json:"error"`
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	// set by OpenSubsonic servers
+	OpenSubsonic   bool                   `json:"openSubsonic"`
+	ServerType     string                 `json:"type"`
+	ServerVersion  string                 `json:"serverVersion"`
+	Extensions     []SubsonicExtension    `json:"openSubsonicExtensions"`
+	Indexes        SubsonicIndexes        `json:"indexes"`
+	Directory      SubsonicDirectory      `json:"directory"`
+	RandomSongs    SubsonicSongs          `json:"randomSongs"`
+	Starred        SubsonicStarred        `json:"starred2"`
+	StarredFolders SubsonicStarredFolders `json:"starred"`
+	TopSongs       SubsonicSongs          `json:"topSongs"`
+	ArtistInfo     SubsonicArtistInfo     `json:"artistInfo"`
+	Artist         SubsonicArtist         `json:"artist"`
+	Album          SubsonicAlbum          `json:"album"`
+	Lyrics         SubsonicLyrics         `json:"lyrics"`
+	LyricsList     SubsonicLyricsList     `json:"lyricsList"`
+	Playlists      SubsonicPlaylists      `json:"playlists"`
+	Playlist       SubsonicPlaylist       `json:"playlist"`
+	Error          SubsonicError          `json:"error"`
 }
 
 type responseWrapper struct {
@@ -170,52 +436,159 @@ func (si *SubsonicId) UnmarshalJSON(b []byte) error {
 }
 
 // requests
-func (connection *SubsonicConnection) GetServerInfo() (*SubsonicResponse, error) {
+func (connection *SubsonicConnection) GetServerInfo(ctx context.Context) (*SubsonicResponse, error) {
 	query := defaultQuery(connection)
 	requestUrl := connection.Host + "/rest/ping" + "?" + query.Encode()
-	return connection.getResponse("GetServerInfo", requestUrl)
+	return connection.getResponse(ctx, "GetServerInfo", requestUrl)
 }
 
-func (connection *SubsonicConnection) GetIndexes() (*SubsonicResponse, error) {
-	query := defaultQuery(connection)
-	requestUrl := connection.Host + "/rest/getIndexes" + "?" + query.Encode()
-	return connection.getResponse("GetIndexes", requestUrl)
+// GetOpenSubsonicExtensions lists the extensions an OpenSubsonic server
+// supports. It needs no credentials.
+func (connection *SubsonicConnection) GetOpenSubsonicExtensions(ctx context.Context) (*SubsonicResponse, error) {
+	query := anonymousQuery(connection)
+	requestUrl := connection.Host + "/rest/getOpenSubsonicExtensions" + "?" + query.Encode()
+	return connection.getResponse(ctx, "GetOpenSubsonicExtensions", requestUrl)
 }
 
-func (connection *SubsonicConnection) GetMusicDirectory(id string) (*SubsonicResponse, error) {
-	if cachedResponse, present := connection.directoryCache[id]; present {
-		return &cachedResponse, nil
+// Negotiate finds out what the server supports, picks how to authenticate and
+// checks the credentials work. Call it once, before sharing the connection.
+//
+// Plain subsonic servers don't know getOpenSubsonicExtensions, they are
+// treated as having no extensions. An API key is only used if the server
+// supports it; otherwise the username and password are, if there are any.
+func (connection *SubsonicConnection) Negotiate(ctx context.Context) error {
+	extensions := make(map[string][]int)
+	response, err := connection.GetOpenSubsonicExtensions(ctx)
+	if err == nil {
+		for _, extension := range response.Extensions {
+			extensions[extension.Name] = extension.Versions
+		}
+	} else if ctx.Err() != nil || isTransient(err) {
+		return err
+	}
+	connection.lock.Lock()
+	connection.extensions = extensions
+	connection.lock.Unlock()
+
+	if connection.ApiKey != "" && !connection.Supports(ExtensionApiKey) {
+		if connection.Username == "" {
+			return fmt.Errorf("the server does not support API keys, set auth.username and auth.password instead")
+		}
+		connection.Logger.Printf("Negotiate: the server does not support API keys, using the password")
+		connection.lock.Lock()
+		connection.ApiKey = ""
+		connection.lock.Unlock()
+	}
+
+	response, err = connection.GetServerInfo(ctx)
+	if err != nil {
+		return err
+	}
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+	// speak the server's version if it is older than ours
+	if response.Version != "" && compareVersions(response.Version, clientApiVersion) < 0 {
+		connection.apiVersion = response.Version
+	}
+	connection.negotiated = true
+	return nil
+}
+
+// Supports reports whether the server has the named OpenSubsonic extension
+func (connection *SubsonicConnection) Supports(extension string) bool {
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+	_, ok := connection.extensions[extension]
+	return ok
+}
+
+// compareVersions compares dotted version numbers like 1.16.1, returning -1, 0
+// or 1. Missing or unparsable parts count as 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// GetIndexes returns the artist index. The server only sends it if it changed
+// since the one in the library, which is returned otherwise, and offline.
+func (connection *SubsonicConnection) GetIndexes(ctx context.Context) (*SubsonicResponse, error) {
+	cached, haveCached := connection.Library.Get("indexes")
+	if haveCached && connection.Offline() {
+		return cached, nil
 	}
 
 	query := defaultQuery(connection)
-	query.Set("id", id)
-	requestUrl := connection.Host + "/rest/getMusicDirectory" + "?" + query.Encode()
-	resp, err := connection.getResponse("GetMusicDirectory", requestUrl)
+	if haveCached && cached.Indexes.LastModified > 0 {
+		query.Set("ifModifiedSince", strconv.FormatInt(cached.Indexes.LastModified, 10))
+	}
+	requestUrl := connection.Host + "/rest/getIndexes" + "?" + query.Encode()
+	resp, err := connection.getResponse(ctx, "GetIndexes", requestUrl)
 	if err != nil {
 		return resp, err
 	}
 
-	// on a sucessful request, cache the response
-	if resp.Status == "ok" {
-		connection.directoryCache[id] = *resp
+	if haveCached && len(resp.Indexes.Index) == 0 && resp.Indexes.LastModified <= cached.Indexes.LastModified {
+		return cached, nil
+	}
+	if err := connection.Library.Put("indexes", resp); err != nil {
+		connection.Logger.Printf("GetIndexes: storing -- %s", err.Error())
 	}
-
 	return resp, nil
 }
 
-func (connection *SubsonicConnection) GetRandomSongs() (*SubsonicResponse, error) {
+// CachedIndexes returns the artist index from the library, without asking
+// the server
+func (connection *SubsonicConnection) CachedIndexes() (*SubsonicResponse, bool) {
+	return connection.Library.Get("indexes")
+}
+
+func (connection *SubsonicConnection) GetMusicDirectory(ctx context.Context, id string) (*SubsonicResponse, error) {
+	return connection.Library.cached(ctx, "directory/"+id, connection.Logger, func(ctx context.Context) (*SubsonicResponse, error) {
+		query := defaultQuery(connection)
+		query.Set("id", id)
+		requestUrl := connection.Host + "/rest/getMusicDirectory" + "?" + query.Encode()
+		return connection.getResponse(ctx, "GetMusicDirectory", requestUrl)
+	})
+}
+
+// ForgetDirectory drops a directory from the cache, so it is fetched again
+// next time. An empty id drops every directory.
+func (connection *SubsonicConnection) ForgetDirectory(id string) {
+	if id == "" {
+		connection.Library.ForgetKind("directory")
+		return
+	}
+	connection.Library.Forget("directory/" + id)
+}
+
+func (connection *SubsonicConnection) GetRandomSongs(ctx context.Context) (*SubsonicResponse, error) {
 	query := defaultQuery(connection)
-        // Let's get 50 random songs, default is 10
+	// Let's get 50 random songs, default is 10
 	query.Set("size", "50")
 	requestUrl := connection.Host + "/rest/getRandomSongs" + "?" + query.Encode()
-	resp, err := connection.getResponse("GetRandomSongs", requestUrl)
+	resp, err := connection.getResponse(ctx, "GetRandomSongs", requestUrl)
 	if err != nil {
 		return resp, err
 	}
 	return resp, nil
 }
 
-func (connection *SubsonicConnection) ScrobbleSubmission(id string, isSubmission bool) (*SubsonicResponse, error) {
+func (connection *SubsonicConnection) ScrobbleSubmission(ctx context.Context, id string, isSubmission bool) (*SubsonicResponse, error) {
 	query := defaultQuery(connection)
 	query.Set("id", id)
 
@@ -223,7 +596,7 @@ func (connection *SubsonicConnection) ScrobbleSubmission(id string, isSubmission
 	query.Set("submission", strconv.FormatBool(isSubmission))
 
 	requestUrl := connection.Host + "/rest/scrobble" + "?" + query.Encode()
-	resp, err := connection.getResponse("ScrobbleSubmission", requestUrl)
+	resp, err := connection.getResponseOnce(ctx, "ScrobbleSubmission", requestUrl)
 	if err != nil {
 		connection.Logger.Printf("ScrobbleSubmission error: %v", err)
 		return resp, err
@@ -231,19 +604,74 @@ func (connection *SubsonicConnection) ScrobbleSubmission(id string, isSubmission
 	return resp, nil
 }
 
-func (connection *SubsonicConnection) GetStarred() (*SubsonicResponse, error) {
+// SubmitScrobble submits a play of song id that started at playedAt. Plays go
+// through Scrobbles, which calls this until the server has them.
+func (connection *SubsonicConnection) SubmitScrobble(ctx context.Context, id string, playedAt time.Time) error {
 	query := defaultQuery(connection)
-	requestUrl := connection.Host + "/rest/getStarred" + "?" + query.Encode()
-	resp, err := connection.getResponse("GetStarred", requestUrl)
+	query.Set("id", id)
+	query.Set("submission", "true")
+	// milliseconds since the epoch
+	query.Set("time", strconv.FormatInt(playedAt.UnixNano()/int64(time.Millisecond), 10))
+
+	requestUrl := connection.Host + "/rest/scrobble" + "?" + query.Encode()
+	_, err := connection.getResponseOnce(ctx, "SubmitScrobble", requestUrl)
+	return err
+}
+
+func (connection *SubsonicConnection) GetArtist(ctx context.Context, id string) (*SubsonicResponse, error) {
+	query := defaultQuery(connection)
+	query.Set("id", id)
+	requestUrl := connection.Host + "/rest/getArtist" + "?" + query.Encode()
+	return connection.getResponse(ctx, "GetArtist", requestUrl)
+}
+
+func (connection *SubsonicConnection) GetAlbum(ctx context.Context, id string) (*SubsonicResponse, error) {
+	return connection.Library.cached(ctx, "album/"+id, connection.Logger, func(ctx context.Context) (*SubsonicResponse, error) {
+		query := defaultQuery(connection)
+		query.Set("id", id)
+		requestUrl := connection.Host + "/rest/getAlbum" + "?" + query.Encode()
+		return connection.getResponse(ctx, "GetAlbum", requestUrl)
+	})
+}
+
+func (connection *SubsonicConnection) GetStarred(ctx context.Context) (*SubsonicResponse, error) {
+	query := defaultQuery(connection)
+	requestUrl := connection.Host + "/rest/getStarred2" + "?" + query.Encode()
+	resp, err := connection.getResponse(ctx, "GetStarred", requestUrl)
 	if err != nil {
 		return resp, err
 	}
 	return resp, nil
 }
 
-func (connection *SubsonicConnection) ToggleStar(id string, starredItems map[string]struct{}) (*SubsonicResponse, error) {
+// GetStarredFolders returns what is starred by folder id, see
+// SubsonicStarredFolders
+func (connection *SubsonicConnection) GetStarredFolders(ctx context.Context) (*SubsonicResponse, error) {
 	query := defaultQuery(connection)
-	query.Set("id",id)
+	requestUrl := connection.Host + "/rest/getStarred" + "?" + query.Encode()
+	return connection.getResponse(ctx, "GetStarredFolders", requestUrl)
+}
+
+// ToggleStar stars or unstars a song or a directory (album/artist folder).
+func (connection *SubsonicConnection) ToggleStar(ctx context.Context, id string, starredItems map[string]struct{}) (*SubsonicResponse, error) {
+	return connection.toggleStar(ctx, "id", id, starredItems)
+}
+
+// ToggleAlbumStar stars or unstars an album by its ID3 id, as returned by
+// getStarred2 and getAlbum.
+func (connection *SubsonicConnection) ToggleAlbumStar(ctx context.Context, id string, starredItems map[string]struct{}) (*SubsonicResponse, error) {
+	return connection.toggleStar(ctx, "albumId", id, starredItems)
+}
+
+// ToggleArtistStar stars or unstars an artist by its ID3 id, as returned by
+// getStarred2 and getArtist.
+func (connection *SubsonicConnection) ToggleArtistStar(ctx context.Context, id string, starredItems map[string]struct{}) (*SubsonicResponse, error) {
+	return connection.toggleStar(ctx, "artistId", id, starredItems)
+}
+
+func (connection *SubsonicConnection) toggleStar(ctx context.Context, param string, id string, starredItems map[string]struct{}) (*SubsonicResponse, error) {
+	query := defaultQuery(connection)
+	query.Set(param, id)
 
 	_, ok := starredItems[id]
 	var action = "star"
@@ -253,34 +681,91 @@ func (connection *SubsonicConnection) ToggleStar(id string, starredItems map[str
 	}
 
 	requestUrl := connection.Host + "/rest/" + action + "?" + query.Encode()
-	resp, err := connection.getResponse("ToggleStar", requestUrl)
-	if err != nil {
-		if (ok) {
-			delete(starredItems, id)
-		} else {
-			starredItems[id] = struct{}{}
-		}
-		return resp, err
-	}
-	return resp, nil
+	// starredItems is left for the caller to update once this succeeds
+	return connection.getResponseOnce(ctx, "ToggleStar", requestUrl)
+}
+
+// GetArtistInfo returns the biography and similar artists of the artist
+// folder id, as listed by getIndexes. Similar artists come with folder ids
+// too. getArtistInfo2 would want an ID3 artist id, which not every server
+// shares with the folder.
+func (connection *SubsonicConnection) GetArtistInfo(ctx context.Context, id string) (*SubsonicResponse, error) {
+	query := defaultQuery(connection)
+	query.Set("id", id)
+	requestUrl := connection.Host + "/rest/getArtistInfo" + "?" + query.Encode()
+	return connection.getResponse(ctx, "GetArtistInfo", requestUrl)
+}
+
+// GetTopSongs returns the most popular songs of an artist, according to
+// last.fm. Note that this looks the artist up by name, not id.
+func (connection *SubsonicConnection) GetTopSongs(ctx context.Context, artist string) (*SubsonicResponse, error) {
+	query := defaultQuery(connection)
+	query.Set("artist", artist)
+	query.Set("count", "50")
+	requestUrl := connection.Host + "/rest/getTopSongs" + "?" + query.Encode()
+	return connection.getResponse(ctx, "GetTopSongs", requestUrl)
+}
+
+func (connection *SubsonicConnection) GetLyrics(ctx context.Context, artist string, title string) (*SubsonicResponse, error) {
+	query := defaultQuery(connection)
+	query.Set("artist", artist)
+	query.Set("title", title)
+	requestUrl := connection.Host + "/rest/getLyrics" + "?" + query.Encode()
+	return connection.getResponse(ctx, "GetLyrics", requestUrl)
+}
+
+// GetLyricsBySongId returns structured, possibly synced, lyrics. This is an
+// OpenSubsonic extension and not available on every server.
+func (connection *SubsonicConnection) GetLyricsBySongId(ctx context.Context, id string) (*SubsonicResponse, error) {
+	query := defaultQuery(connection)
+	query.Set("id", id)
+	requestUrl := connection.Host + "/rest/getLyricsBySongId" + "?" + query.Encode()
+	return connection.getResponse(ctx, "GetLyricsBySongId", requestUrl)
 }
 
-func (connection *SubsonicConnection) GetPlaylists() (*SubsonicResponse, error) {
+// SetRating sets the rating of a song, album or artist. A rating of 0 removes
+// the rating.
+func (connection *SubsonicConnection) SetRating(ctx context.Context, id string, rating int) (*SubsonicResponse, error) {
+	query := defaultQuery(connection)
+	query.Set("id", id)
+	query.Set("rating", strconv.Itoa(rating))
+	requestUrl := connection.Host + "/rest/setRating" + "?" + query.Encode()
+	return connection.getResponseOnce(ctx, "SetRating", requestUrl)
+}
+
+// GetPlaylists returns every playlist with its songs. Only playlists that
+// changed since they were put in the library are fetched again. Offline, the
+// library's are returned.
+func (connection *SubsonicConnection) GetPlaylists(ctx context.Context) (*SubsonicResponse, error) {
+	if cached, ok := connection.Library.Get("playlists"); ok && connection.Offline() {
+		return cached, nil
+	}
 	query := defaultQuery(connection)
 	requestUrl := connection.Host + "/rest/getPlaylists" + "?" + query.Encode()
-	resp, err := connection.getResponse("GetPlaylists", requestUrl)
+	resp, err := connection.getResponse(ctx, "GetPlaylists", requestUrl)
 	if err != nil {
 		return resp, err
 	}
 
+	known := make(map[SubsonicId]SubsonicPlaylist)
+	if cached, ok := connection.Library.Get("playlists"); ok {
+		for _, playlist := range cached.Playlists.Playlists {
+			known[playlist.Id] = playlist
+		}
+	}
+
 	for i := 0; i < len(resp.Playlists.Playlists); i++ {
 		playlist := &resp.Playlists.Playlists[i]
 
 		if playlist.SongCount == 0 {
 			continue
 		}
+		if old, ok := known[playlist.Id]; ok && playlist.Changed != "" && old.Changed == playlist.Changed && old.SongCount == playlist.SongCount {
+			playlist.Entries = old.Entries
+			continue
+		}
 
-		response, err := connection.GetPlaylist(string(playlist.Id))
+		response, err := connection.GetPlaylist(ctx, string(playlist.Id))
 
 		if err != nil {
 			return nil, err
@@ -289,79 +774,482 @@ func (connection *SubsonicConnection) GetPlaylists() (*SubsonicResponse, error)
 		playlist.Entries = response.Playlist.Entries
 	}
 
+	if err := connection.Library.Put("playlists", resp); err != nil {
+		connection.Logger.Printf("GetPlaylists: storing -- %s", err.Error())
+	}
 	return resp, nil
 }
 
-func (connection *SubsonicConnection) GetPlaylist(id string) (*SubsonicResponse, error) {
+// CachedPlaylists returns the playlists from the library, without asking the
+// server
+func (connection *SubsonicConnection) CachedPlaylists() (*SubsonicResponse, bool) {
+	return connection.Library.Get("playlists")
+}
+
+func (connection *SubsonicConnection) GetPlaylist(ctx context.Context, id string) (*SubsonicResponse, error) {
 	query := defaultQuery(connection)
 	query.Set("id", id)
 
 	requestUrl := connection.Host + "/rest/getPlaylist" + "?" + query.Encode()
-	return connection.getResponse("GetPlaylist", requestUrl)
+	return connection.getResponse(ctx, "GetPlaylist", requestUrl)
 }
 
-func (connection *SubsonicConnection) CreatePlaylist(name string) (*SubsonicResponse, error) {
+// CreatePlaylist creates a playlist holding songIds. That is one request on
+// servers with the formPost extension; others get the songs that don't fit in
+// a url afterwards, see songIdsThatFit.
+func (connection *SubsonicConnection) CreatePlaylist(ctx context.Context, name string, songIds ...string) (*SubsonicResponse, error) {
 	query := defaultQuery(connection)
 	query.Set("name", name)
 	requestUrl := connection.Host + "/rest/createPlaylist" + "?" + query.Encode()
-	return connection.getResponse("GetPlaylist", requestUrl)
+	n := connection.songIdsThatFit(len(requestUrl), "songId", songIds)
+	for _, id := range songIds[:n] {
+		query.Add("songId", id)
+	}
+	requestUrl = connection.Host + "/rest/createPlaylist" + "?" + query.Encode()
+	response, err := connection.getResponseOnce(ctx, "CreatePlaylist", requestUrl)
+	if err != nil || n == len(songIds) {
+		return response, err
+	}
+	if response.Playlist.Id == "" {
+		// servers before 1.14.0 don't say what they created
+		return response, fmt.Errorf("CreatePlaylist: the server didn't say which playlist it created, %d songs weren't added", len(songIds)-n)
+	}
+	return response, connection.addSongs(ctx, string(response.Playlist.Id), songIds[n:])
+}
+
+// ReplacePlaylist replaces the songs of playlist id with songIds, which is
+// also how songs are reordered. Like CreatePlaylist, it takes more than one
+// request for a long playlist on servers without formPost.
+func (connection *SubsonicConnection) ReplacePlaylist(ctx context.Context, id string, songIds []string) (*SubsonicResponse, error) {
+	query := defaultQuery(connection)
+	query.Set("playlistId", id)
+	requestUrl := connection.Host + "/rest/createPlaylist" + "?" + query.Encode()
+	n := connection.songIdsThatFit(len(requestUrl), "songId", songIds)
+	for _, songId := range songIds[:n] {
+		query.Add("songId", songId)
+	}
+	requestUrl = connection.Host + "/rest/createPlaylist" + "?" + query.Encode()
+	response, err := connection.getResponseOnce(ctx, "ReplacePlaylist", requestUrl)
+	if err != nil {
+		return response, err
+	}
+	return response, connection.addSongs(ctx, id, songIds[n:])
+}
+
+// maxUrlLength is how long a url stmp sends may get. Servers and the proxies
+// in front of them commonly turn away anything over 8K with a 414.
+const maxUrlLength = 6000
+
+// songIdsThatFit returns how many of songIds fit, as repeated param
+// parameters, in a url that is already length long. That is at least one, so
+// every request gets somewhere. Servers with the formPost extension get the
+// query in the body, which has no such limit, and so take them all.
+func (connection *SubsonicConnection) songIdsThatFit(length int, param string, songIds []string) int {
+	if connection.Supports(ExtensionFormPost) {
+		return len(songIds)
+	}
+	for i, id := range songIds {
+		length += len("&" + param + "=" + url.QueryEscape(id))
+		if i > 0 && length > maxUrlLength {
+			return i
+		}
+	}
+	return len(songIds)
 }
 
-func (connection *SubsonicConnection) getResponse(caller, requestUrl string) (*SubsonicResponse, error) {
-	res, err := http.Get(requestUrl)
+// addSongs appends songIds to playlist id in as many requests as it takes, for
+// the songs CreatePlaylist, ReplacePlaylist and UpdatePlaylist couldn't fit in
+// their first
+func (connection *SubsonicConnection) addSongs(ctx context.Context, id string, songIds []string) error {
+	for len(songIds) > 0 {
+		query := defaultQuery(connection)
+		query.Set("playlistId", id)
+		requestUrl := connection.Host + "/rest/updatePlaylist" + "?" + query.Encode()
+		n := connection.songIdsThatFit(len(requestUrl), "songIdToAdd", songIds)
+		for _, songId := range songIds[:n] {
+			query.Add("songIdToAdd", songId)
+		}
+		requestUrl = connection.Host + "/rest/updatePlaylist" + "?" + query.Encode()
+		if _, err := connection.getResponseOnce(ctx, "UpdatePlaylist", requestUrl); err != nil {
+			return err
+		}
+		songIds = songIds[n:]
+	}
+	return nil
+}
 
+// PlaylistUpdate is what UpdatePlaylist changes. Nil fields are left as they
+// are.
+type PlaylistUpdate struct {
+	Name    *string
+	Comment *string
+	Public  *bool
+	// songs to append
+	SongIdsToAdd []string
+	// positions of songs to remove, as they are before the update
+	SongIndexesToRemove []int
+}
+
+// UpdatePlaylist changes playlist id in one request, unless there are more
+// songs to add than fit in a url, see songIdsThatFit. Those are added after
+// everything else has changed.
+func (connection *SubsonicConnection) UpdatePlaylist(ctx context.Context, id string, update PlaylistUpdate) error {
+	query := defaultQuery(connection)
+	query.Set("playlistId", id)
+	if update.Name != nil {
+		query.Set("name", *update.Name)
+	}
+	if update.Comment != nil {
+		query.Set("comment", *update.Comment)
+	}
+	if update.Public != nil {
+		query.Set("public", strconv.FormatBool(*update.Public))
+	}
+	for _, index := range update.SongIndexesToRemove {
+		query.Add("songIndexToRemove", strconv.Itoa(index))
+	}
+	requestUrl := connection.Host + "/rest/updatePlaylist" + "?" + query.Encode()
+	n := connection.songIdsThatFit(len(requestUrl), "songIdToAdd", update.SongIdsToAdd)
+	for _, songId := range update.SongIdsToAdd[:n] {
+		query.Add("songIdToAdd", songId)
+	}
+	requestUrl = connection.Host + "/rest/updatePlaylist" + "?" + query.Encode()
+	if _, err := connection.getResponseOnce(ctx, "UpdatePlaylist", requestUrl); err != nil {
+		return err
+	}
+	return connection.addSongs(ctx, id, update.SongIdsToAdd[n:])
+}
+
+// RefreshPlaylist fetches playlist id again after it was changed, and puts it
+// in the library in place of the old one, so the other playlists needn't be
+// fetched
+func (connection *SubsonicConnection) RefreshPlaylist(ctx context.Context, id string) (*SubsonicPlaylist, error) {
+	response, err := connection.GetPlaylist(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	playlist := response.Playlist
 
-	if res.Body != nil {
-		defer res.Body.Close()
+	if cached, ok := connection.Library.Get("playlists"); ok {
+		// the cached response is shared, change a copy
+		updated := *cached
+		updated.Playlists.Playlists = nil
+		found := false
+		for _, old := range cached.Playlists.Playlists {
+			if old.Id == playlist.Id {
+				old = playlist
+				found = true
+			}
+			updated.Playlists.Playlists = append(updated.Playlists.Playlists, old)
+		}
+		if !found {
+			updated.Playlists.Playlists = append(updated.Playlists.Playlists, playlist)
+		}
+		if err := connection.Library.Put("playlists", &updated); err != nil {
+			connection.Logger.Printf("RefreshPlaylist: storing -- %s", err.Error())
+		}
 	}
+	return &playlist, nil
+}
 
-	responseBody, readErr := ioutil.ReadAll(res.Body)
+// getResponse performs a read request and decodes the subsonic response,
+// retrying transient failures. Responses with status "failed" are returned
+// along with their error as a *SubsonicError.
+func (connection *SubsonicConnection) getResponse(ctx context.Context, caller, requestUrl string) (*SubsonicResponse, error) {
+	body, _, err := connection.fetch(ctx, requestUrl, connection.Retries)
+	if err != nil {
+		return nil, err
+	}
+	return decodeResponse(caller, body)
+}
 
-	if readErr != nil {
+// getResponseOnce is getResponse for requests that change something on the
+// server. Those are never retried, since a request that timed out may still
+// have gone through.
+func (connection *SubsonicConnection) getResponseOnce(ctx context.Context, caller, requestUrl string) (*SubsonicResponse, error) {
+	body, _, err := connection.fetch(ctx, requestUrl, 0)
+	if err != nil {
 		return nil, err
 	}
+	return decodeResponse(caller, body)
+}
 
+func decodeResponse(caller string, body []byte) (*SubsonicResponse, error) {
 	var decodedBody responseWrapper
-	err = json.Unmarshal(responseBody, &decodedBody)
+	if err := json.Unmarshal(body, &decodedBody); err != nil {
+		return nil, fmt.Errorf("%s: decoding response: %w", caller, err)
+	}
+
+	if decodedBody.Response.Status != "ok" {
+		return &decodedBody.Response, &decodedBody.Response.Error
+	}
+
+	return &decodedBody.Response, nil
+}
+
+// fetch GETs requestUrl and returns the body of a 2xx response. Network errors,
+// 5xx and 429 responses are retried up to retries times, waiting twice as long
+// after every attempt, unless ctx is done first. A server that still can't be
+// reached puts the connection offline.
+func (connection *SubsonicConnection) fetch(ctx context.Context, requestUrl string, retries int) ([]byte, http.Header, error) {
+	if connection.Offline() {
+		return nil, nil, ErrOffline
+	}
+	delay := connection.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		body, header, err := connection.fetchOnce(ctx, requestUrl)
+		if err == nil || attempt >= retries || ctx.Err() != nil || !isTransient(err) {
+			if err != nil && ctx.Err() == nil && isUnreachable(err) {
+				connection.goOffline()
+			}
+			return body, header, err
+		}
+
+		// jitter, so clients that failed together don't all come back together
+		wait := delay << uint(attempt)
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
 
+func (connection *SubsonicConnection) fetchOnce(ctx context.Context, requestUrl string) ([]byte, http.Header, error) {
+	client := connection.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := connection.do(ctx, client, requestUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, res.Header, nil
+}
+
+// do sends the request for requestUrl and returns the response if it is a 2xx
+// one. The caller has to close its body.
+func (connection *SubsonicConnection) do(ctx context.Context, client *http.Client, requestUrl string) (*http.Response, error) {
+	req, err := connection.newRequest(ctx, requestUrl)
 	if err != nil {
 		return nil, err
 	}
+	if connection.UserAgent != "" {
+		req.Header.Set("User-Agent", connection.UserAgent)
+	}
 
-	return &decodedBody.Response, nil
+	res, err := client.Do(req)
+	if err != nil {
+		// the url holds the credentials, keep them out of the log
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactUrl(urlErr.URL)
+		}
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// read what's left so the connection can be reused
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		return nil, &HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+	return res, nil
+}
+
+// newRequest builds the request for requestUrl. Servers with the formPost
+// extension get the query as a form body, which keeps the credentials out of
+// their access logs.
+func (connection *SubsonicConnection) newRequest(ctx context.Context, requestUrl string) (*http.Request, error) {
+	if !connection.Supports(ExtensionFormPost) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+	}
+
+	parsed, err := url.Parse(requestUrl)
+	if err != nil {
+		return nil, err
+	}
+	form := parsed.RawQuery
+	parsed.RawQuery = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, parsed.String(), strings.NewReader(form))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// isTransient reports whether a request that failed with err may succeed if
+// it is sent again
+func isTransient(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	// anything else that isn't a failure to even build the request is a
+	// network problem: refused connections, resets, timeouts
+	return errors.As(err, &urlErr)
 }
 
-func (connection *SubsonicConnection) DeletePlaylist(id string) error {
+// redactUrl drops the query string, which holds the password or token
+func redactUrl(requestUrl string) string {
+	if i := strings.IndexByte(requestUrl, '?'); i >= 0 {
+		return requestUrl[:i]
+	}
+	return requestUrl
+}
+
+func (connection *SubsonicConnection) DeletePlaylist(ctx context.Context, id string) error {
 	query := defaultQuery(connection)
 	query.Set("id", id)
 	requestUrl := connection.Host + "/rest/deletePlaylist" + "?" + query.Encode()
-	_, err := http.Get(requestUrl)
+	_, err := connection.getResponseOnce(ctx, "DeletePlaylist", requestUrl)
 	return err
 }
 
-func (connection *SubsonicConnection) AddSongToPlaylist(playlistId string, songId string) error {
+func (connection *SubsonicConnection) AddSongToPlaylist(ctx context.Context, playlistId string, songId string) error {
 	query := defaultQuery(connection)
 	query.Set("playlistId", playlistId)
 	query.Set("songIdToAdd", songId)
 	requestUrl := connection.Host + "/rest/updatePlaylist" + "?" + query.Encode()
-	_, err := http.Get(requestUrl)
+	_, err := connection.getResponseOnce(ctx, "AddSongToPlaylist", requestUrl)
 	return err
 }
 
-func (connection *SubsonicConnection) RemoveSongFromPlaylist(playlistId string, songIndex int) error {
+func (connection *SubsonicConnection) RemoveSongFromPlaylist(ctx context.Context, playlistId string, songIndex int) error {
 	query := defaultQuery(connection)
 	query.Set("playlistId", playlistId)
 	query.Set("songIndexToRemove", strconv.Itoa(songIndex))
 	requestUrl := connection.Host + "/rest/updatePlaylist" + "?" + query.Encode()
-	_, err := http.Get(requestUrl)
+	_, err := connection.getResponseOnce(ctx, "RemoveSongFromPlaylist", requestUrl)
 	return err
 }
 
+// GetCoverArt returns the raw image data for a cover art id, scaled by the
+// server to size pixels (or the original size if size is 0). Images are cached
+// on disk under CacheDir, since they never change for a given id.
+func (connection *SubsonicConnection) GetCoverArt(ctx context.Context, id string, size int) ([]byte, error) {
+	var cachePath string
+	if connection.CacheDir != "" {
+		name := fmt.Sprintf("%x", md5.Sum([]byte(id+"@"+strconv.Itoa(size))))
+		cachePath = filepath.Join(connection.CacheDir, "covers", name)
+		if data, err := ioutil.ReadFile(cachePath); err == nil {
+			return data, nil
+		}
+	}
+
+	query := defaultQuery(connection)
+	query.Set("id", id)
+	if size > 0 {
+		query.Set("size", strconv.Itoa(size))
+	}
+	requestUrl := connection.Host + "/rest/getCoverArt" + "?" + query.Encode()
+	data, header, err := connection.fetch(ctx, requestUrl, connection.Retries)
+	if err != nil {
+		return nil, err
+	}
+
+	// failures come back as a regular subsonic response instead of an image
+	if strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+		var decodedBody responseWrapper
+		if err := json.Unmarshal(data, &decodedBody); err != nil {
+			return nil, err
+		}
+		return nil, &decodedBody.Response.Error
+	}
+
+	if cachePath != "" {
+		err := os.MkdirAll(filepath.Dir(cachePath), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(cachePath, data, 0644)
+		}
+		if err != nil {
+			connection.Logger.Printf("GetCoverArt: caching %s -- %s", id, err.Error())
+		}
+	}
+
+	return data, nil
+}
+
+// Download writes the original file of song id to w, calling progress, if
+// set, as the data comes in. total is -1 if the server doesn't say.
+func (connection *SubsonicConnection) Download(ctx context.Context, id string, w io.Writer, progress func(done, total int64)) error {
+	query := defaultQuery(connection)
+	query.Set("id", id)
+	requestUrl := connection.Host + "/rest/download" + "?" + query.Encode()
+	if connection.Offline() {
+		return ErrOffline
+	}
+
+	// a song takes as long as it takes, ctx is what ends a download early
+	var client http.Client
+	if connection.Client != nil {
+		client = *connection.Client
+	}
+	client.Timeout = 0
+
+	res, err := connection.do(ctx, &client, requestUrl)
+	if err != nil {
+		if ctx.Err() == nil && isUnreachable(err) {
+			connection.goOffline()
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	// failures come back as a regular subsonic response instead of a file
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		_, err = decodeResponse("Download", body)
+		if err == nil {
+			err = errors.New("Download: the server sent no file")
+		}
+		return err
+	}
+
+	var done int64
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := res.Body.Read(buffer)
+		if n > 0 {
+			if _, err := w.Write(buffer[:n]); err != nil {
+				return err
+			}
+			done += int64(n)
+			if progress != nil {
+				progress(done, res.ContentLength)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
 // note that this function does not make a request, it just formats the play url
-// to pass to mpv
+// to pass to mpv. Downloaded songs are played from disk instead, see
+// LocalFile.
 func (connection *SubsonicConnection) GetPlayUrl(entity *SubsonicEntity) string {
 	// we don't want to call stream on a directory
 	if entity.IsDirectory {
@@ -369,6 +1257,61 @@ func (connection *SubsonicConnection) GetPlayUrl(entity *SubsonicEntity) string
 	}
 
 	query := defaultQuery(connection)
+	if connection.ProxyBasicAuth {
+		query = anonymousQuery(connection)
+	}
 	query.Set("id", entity.Id)
 	return connection.Host + "/rest/stream" + "?" + query.Encode()
 }
+
+// LocalFile returns the downloaded file of item, or "" to stream it. The player
+// asks as each track starts, see Player.SetResolver, so a song downloaded or
+// evicted while queued is played from wherever it is by then.
+func (connection *SubsonicConnection) LocalFile(item QueueItem) string {
+	if connection.Downloads == nil {
+		return ""
+	}
+	return connection.Downloads.Path(item.Id)
+}
+
+// Close stops whatever the connection is doing in the background
+func (connection *SubsonicConnection) Close() {
+	connection.lock.Lock()
+	connection.closed = true
+	connection.lock.Unlock()
+	if connection.Downloads != nil {
+		connection.Downloads.Close()
+	}
+	if connection.Scrobbles != nil {
+		connection.Scrobbles.Close()
+	}
+}
+
+// StreamHeaders returns the HTTP headers, in "Name: value" form, the player
+// has to send along with play urls. Unless ProxyBasicAuth is set there are
+// none, the credentials being in the url. The header is basic auth, which the
+// subsonic API doesn't know; only a reverse proxy that checks it and passes
+// requests on with credentials of its own makes streams work this way.
+func (connection *SubsonicConnection) StreamHeaders() []string {
+	if !connection.ProxyBasicAuth {
+		return nil
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(connection.Username + ":" + connection.Password))
+	return []string{"Authorization: Basic " + credentials}
+}
+
+// CheckProxyBasicAuth refuses ProxyBasicAuth where it can't work or would give
+// the password away: basic auth sends it as it is, so only over https, and
+// there has to be one to send.
+func (connection *SubsonicConnection) CheckProxyBasicAuth() error {
+	if !connection.ProxyBasicAuth {
+		return nil
+	}
+	if !strings.HasPrefix(strings.ToLower(connection.Host), "https://") {
+		return fmt.Errorf("server.proxyBasicAuth sends the password with every stream, so it needs an https host, not %s", connection.Host)
+	}
+	if connection.Username == "" || connection.Password == "" {
+		return errors.New("server.proxyBasicAuth needs auth.username and a password")
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const okResponse = `{"subsonic-response":{"status":"ok","version":"1.16.1"}}`
+
+// testLogger returns a Logger whose output is thrown away
+func testLogger() Logger {
+	logger := Logger{make(chan string, 100), make(chan string, 1)}
+	go func() {
+		for range logger.prints {
+		}
+	}()
+	return logger
+}
+
+// newTestConnection returns a connection to a local server running handler
+func newTestConnection(t *testing.T, handler http.HandlerFunc) *SubsonicConnection {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	connection := &SubsonicConnection{
+		Username:          "user",
+		Password:          "secret",
+		Host:              server.URL,
+		Logger:            testLogger(),
+		Client:            NewHTTPClient(5*time.Second, false),
+		RetryDelay:        time.Millisecond,
+		ReconnectInterval: time.Hour,
+	}
+	t.Cleanup(connection.Close)
+	return connection
+}
+
+// statusSequence answers with statuses in turn, then with the last one, and
+// counts the requests in attempts. 200 is a subsonic "ok" response.
+func statusSequence(attempts *int32, statuses ...int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(attempts, 1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		status := statuses[n-1]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprint(w, okResponse)
+	}
+}
+
+func TestGetResponseRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		statuses []int
+		attempts int32
+		ok       bool
+	}{
+		{"ok at once", 2, []int{200}, 1, true},
+		{"server error then ok", 2, []int{500, 503, 200}, 3, true},
+		{"too many requests then ok", 2, []int{429, 200}, 2, true},
+		{"retries run out", 2, []int{500}, 3, false},
+		{"no retries", 0, []int{500}, 1, false},
+		{"not found is final", 2, []int{404}, 1, false},
+		{"unauthorized is final", 2, []int{401}, 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts int32
+			connection := newTestConnection(t, statusSequence(&attempts, test.statuses...))
+			connection.Retries = test.retries
+
+			_, err := connection.GetRandomSongs(context.Background())
+			if test.ok && err != nil {
+				t.Errorf("got error %v", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("got no error")
+			}
+			if got := atomic.LoadInt32(&attempts); got != test.attempts {
+				t.Errorf("got %d attempts, want %d", got, test.attempts)
+			}
+		})
+	}
+}
+
+func TestGetResponseDoesNotRetryFailedResponses(t *testing.T) {
+	var attempts int32
+	connection := newTestConnection(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		fmt.Fprint(w, `{"subsonic-response":{"status":"failed","error":{"code":70,"message":"not found"}}}`)
+	})
+	connection.Retries = 3
+
+	_, err := connection.GetArtist(context.Background(), "1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("got %d attempts, want 1", got)
+	}
+}
+
+func TestWritesAreNotRetried(t *testing.T) {
+	writes := []struct {
+		name string
+		call func(connection *SubsonicConnection) error
+	}{
+		{"DeletePlaylist", func(connection *SubsonicConnection) error {
+			return connection.DeletePlaylist(context.Background(), "1")
+		}},
+		{"UpdatePlaylist", func(connection *SubsonicConnection) error {
+			return connection.UpdatePlaylist(context.Background(), "1", PlaylistUpdate{SongIdsToAdd: []string{"2"}})
+		}},
+		{"SetRating", func(connection *SubsonicConnection) error {
+			_, err := connection.SetRating(context.Background(), "1", 5)
+			return err
+		}},
+		{"SubmitScrobble", func(connection *SubsonicConnection) error {
+			return connection.SubmitScrobble(context.Background(), "1", time.Now())
+		}},
+	}
+	for _, write := range writes {
+		t.Run(write.name, func(t *testing.T) {
+			var attempts int32
+			connection := newTestConnection(t, statusSequence(&attempts, 500, 200))
+			connection.Retries = 3
+
+			if err := write.call(connection); err == nil {
+				t.Error("got no error")
+			}
+			if got := atomic.LoadInt32(&attempts); got != 1 {
+				t.Errorf("got %d attempts, want 1", got)
+			}
+		})
+	}
+}
+
+func TestFetchStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var attempts int32
+	connection := newTestConnection(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		cancel()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	connection.Retries = 5
+	// long enough that only cancelling ends the wait
+	connection.RetryDelay = time.Hour
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := connection.GetRandomSongs(ctx)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetRandomSongs kept waiting after ctx was cancelled")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("got %d attempts, want 1", got)
+	}
+	if connection.Offline() {
+		t.Error("cancelling put the connection offline")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var attempts int32
+	connection := newTestConnection(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		<-release
+	})
+	// runs before the server is closed, which waits for the handler
+	t.Cleanup(func() { close(release) })
+	connection.Client = NewHTTPClient(50*time.Millisecond, false)
+	connection.Retries = 1
+
+	start := time.Now()
+	_, err := connection.GetRandomSongs(context.Background())
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) || !urlErr.Timeout() {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s, the timeout is 50ms", elapsed)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("got %d attempts, want 2", got)
+	}
+	if !connection.Offline() {
+		t.Error("a server that never answers should put the connection offline")
+	}
+}
+
+// standInServer answers like a subsonic server speaking version, with the
+// OpenSubsonic extensions given, or like a plain subsonic server if there are
+// none. Every request is passed to record, its form parsed.
+func standInServer(version string, extensions []string, record func(r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if record != nil {
+			record(r)
+		}
+		switch r.URL.Path {
+		case "/rest/getOpenSubsonicExtensions":
+			if len(extensions) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			var list []string
+			for _, name := range extensions {
+				list = append(list, fmt.Sprintf(`{"name":%q,"versions":[1]}`, name))
+			}
+			fmt.Fprintf(w, `{"subsonic-response":{"status":"ok","version":%q,"openSubsonic":true,"openSubsonicExtensions":[%s]}}`,
+				version, strings.Join(list, ","))
+		default:
+			fmt.Fprintf(w, `{"subsonic-response":{"status":"ok","version":%q}}`, version)
+		}
+	}
+}
+
+// requestLog keeps the requests a stand-in server got
+type requestLog struct {
+	lock     sync.Mutex
+	requests []*http.Request
+}
+
+func (l *requestLog) record(r *http.Request) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.requests = append(l.requests, r)
+}
+
+// last returns the latest request for path
+func (l *requestLog) last(t *testing.T, path string) *http.Request {
+	t.Helper()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i := len(l.requests) - 1; i >= 0; i-- {
+		if l.requests[i].URL.Path == path {
+			return l.requests[i]
+		}
+	}
+	t.Fatalf("no request for %s", path)
+	return nil
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    string
+		extensions []string
+		// what stmp should speak afterwards
+		wantVersion string
+		lyrics      bool
+	}{
+		{"plain subsonic", "1.15.0", nil, "1.15.0", false},
+		{"opensubsonic", "1.16.1", []string{ExtensionSongLyrics}, "1.16.1", true},
+		{"newer than stmp", "1.17.0", []string{ExtensionSongLyrics}, clientApiVersion, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var log requestLog
+			connection := newTestConnection(t, standInServer(test.version, test.extensions, log.record))
+
+			if err := connection.Negotiate(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := connection.Supports(ExtensionSongLyrics); got != test.lyrics {
+				t.Errorf("Supports(songLyrics) is %v, want %v", got, test.lyrics)
+			}
+			if _, err := connection.GetRandomSongs(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := log.last(t, "/rest/getRandomSongs").Form.Get("v"); got != test.wantVersion {
+				t.Errorf("speaking version %s, want %s", got, test.wantVersion)
+			}
+		})
+	}
+}
+
+func TestNegotiateFailsWhileServerIsDown(t *testing.T) {
+	connection := newTestConnection(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if err := connection.Negotiate(context.Background()); err == nil {
+		t.Fatal("Negotiate worked without a server")
+	}
+	if !connection.Offline() {
+		t.Error("a server that is down should put the connection offline")
+	}
+}
+
+func TestApiKeyAuthentication(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+		username   string
+		// "apiKey", "token" or "" for Negotiate failing
+		want string
+	}{
+		{"supported", []string{ExtensionApiKey}, "", "apiKey"},
+		{"supported, with a username", []string{ExtensionApiKey}, "user", "apiKey"},
+		{"unsupported, password instead", []string{ExtensionSongLyrics}, "user", "token"},
+		{"plain subsonic, password instead", nil, "user", "token"},
+		{"unsupported, no username", []string{ExtensionSongLyrics}, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var log requestLog
+			connection := newTestConnection(t, standInServer("1.16.1", test.extensions, log.record))
+			connection.ApiKey = "key"
+			connection.Username = test.username
+
+			err := connection.Negotiate(context.Background())
+			if test.want == "" {
+				if err == nil {
+					t.Error("Negotiate worked without any usable credentials")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			form := log.last(t, "/rest/ping").Form
+			switch test.want {
+			case "apiKey":
+				if form.Get("apiKey") != "key" {
+					t.Errorf("no apiKey in %v", form)
+				}
+				// the username mustn't go along with an API key
+				for _, name := range []string{"u", "p", "t", "s"} {
+					if form.Get(name) != "" {
+						t.Errorf("%s sent along with the API key", name)
+					}
+				}
+			case "token":
+				if form.Get("apiKey") != "" {
+					t.Error("the API key was sent to a server that doesn't support it")
+				}
+				if form.Get("u") != "user" || form.Get("t") == "" || form.Get("s") == "" {
+					t.Errorf("no username and token in %v", form)
+				}
+			}
+		})
+	}
+}
+
+func TestFormPost(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+		post       bool
+	}{
+		{"supported", []string{ExtensionFormPost}, true},
+		{"unsupported, query instead", []string{ExtensionSongLyrics}, false},
+		{"plain subsonic, query instead", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var log requestLog
+			connection := newTestConnection(t, standInServer("1.16.1", test.extensions, log.record))
+			if err := connection.Negotiate(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := connection.GetArtist(context.Background(), "42"); err != nil {
+				t.Fatal(err)
+			}
+
+			r := log.last(t, "/rest/getArtist")
+			if r.Form.Get("id") != "42" || r.Form.Get("u") != "user" {
+				t.Errorf("parameters missing from %v", r.Form)
+			}
+			if test.post {
+				if r.Method != http.MethodPost || r.URL.RawQuery != "" {
+					t.Errorf("got %s with query %q, want a POST form", r.Method, r.URL.RawQuery)
+				}
+			} else if r.Method != http.MethodGet || r.URL.Query().Get("id") != "42" {
+				t.Errorf("got %s with query %q, want a GET", r.Method, r.URL.RawQuery)
+			}
+		})
+	}
+}
+
+func TestCheckProxyBasicAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection *SubsonicConnection
+		ok         bool
+	}{
+		{"off", &SubsonicConnection{Host: "http://music.example"}, true},
+		{"https with a password", &SubsonicConnection{Host: "https://music.example", Username: "user", Password: "secret", ProxyBasicAuth: true}, true},
+		{"http", &SubsonicConnection{Host: "http://music.example", Username: "user", Password: "secret", ProxyBasicAuth: true}, false},
+		{"no password", &SubsonicConnection{Host: "https://music.example", Username: "user", ApiKey: "key", ProxyBasicAuth: true}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.connection.CheckProxyBasicAuth()
+			if (err == nil) != test.ok {
+				t.Errorf("got %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+// TestLongPlaylistsAreSplit sends more songs than fit in a url; servers
+// without formPost get them over several requests, in order
+func TestLongPlaylistsAreSplit(t *testing.T) {
+	var songIds []string
+	for i := 0; i < 1000; i++ {
+		songIds = append(songIds, fmt.Sprintf("3f2504e0-4f89-11d3-9a0c-%012d", i))
+	}
+
+	calls := []struct {
+		name  string
+		first string // the request the first songs go with
+		call  func(connection *SubsonicConnection) error
+	}{
+		{"CreatePlaylist", "/rest/createPlaylist", func(connection *SubsonicConnection) error {
+			_, err := connection.CreatePlaylist(context.Background(), "long", songIds...)
+			return err
+		}},
+		{"ReplacePlaylist", "/rest/createPlaylist", func(connection *SubsonicConnection) error {
+			_, err := connection.ReplacePlaylist(context.Background(), "p1", songIds)
+			return err
+		}},
+		{"UpdatePlaylist", "/rest/updatePlaylist", func(connection *SubsonicConnection) error {
+			return connection.UpdatePlaylist(context.Background(), "p1", PlaylistUpdate{SongIdsToAdd: songIds})
+		}},
+	}
+	tests := []struct {
+		name       string
+		extensions []string
+		split      bool
+	}{
+		{"formPost", []string{ExtensionFormPost}, false},
+		{"no formPost", []string{ExtensionSongLyrics}, true},
+	}
+	for _, test := range tests {
+		for _, call := range calls {
+			t.Run(test.name+" "+call.name, func(t *testing.T) {
+				var log requestLog
+				standIn := standInServer("1.16.1", test.extensions, log.record)
+				connection := newTestConnection(t, func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/rest/createPlaylist" {
+						r.ParseForm()
+						log.record(r)
+						fmt.Fprint(w, `{"subsonic-response":{"status":"ok","version":"1.16.1","playlist":{"id":"p1"}}}`)
+						return
+					}
+					standIn(w, r)
+				})
+				if err := connection.Negotiate(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				log.requests = nil
+
+				if err := call.call(connection); err != nil {
+					t.Fatal(err)
+				}
+
+				var sent []string
+				for i, r := range log.requests {
+					if i == 0 && r.URL.Path != call.first {
+						t.Errorf("first request %s, want %s", r.URL.Path, call.first)
+					}
+					if i > 0 && (r.URL.Path != "/rest/updatePlaylist" || r.Form.Get("playlistId") != "p1") {
+						t.Errorf("request %d: %s for playlist %q, want the rest added to p1", i, r.URL.Path, r.Form.Get("playlistId"))
+					}
+					if length := len(connection.Host + r.URL.RequestURI()); length > maxUrlLength {
+						t.Errorf("request %d: the url is %d long", i, length)
+					}
+					sent = append(sent, r.Form["songId"]...)
+					sent = append(sent, r.Form["songIdToAdd"]...)
+				}
+				if !reflect.DeepEqual(sent, songIds) {
+					t.Errorf("sent %d songs, want all %d in order", len(sent), len(songIds))
+				}
+				if split := len(log.requests) > 1; split != test.split {
+					t.Errorf("%d requests, want split %v", len(log.requests), test.split)
+				}
+			})
+		}
+	}
+}
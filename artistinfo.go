@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// createArtistInfoPane builds the biography and similar artists pane shown
+// next to the entity list in the browser
+func (ui *Ui) createArtistInfoPane() *tview.Flex {
+	ui.artistBio = tview.NewTextView().
+		SetDynamicColors(true).
+		SetWordWrap(true)
+	ui.artistBio.SetBorder(true).SetTitle("Biography")
+
+	ui.similarArtistList = tview.NewList().ShowSecondaryText(false).
+		SetSelectedFocusOnly(true)
+	ui.similarArtistList.SetBorder(true).SetTitle("Similar Artists")
+
+	ui.similarArtistList.SetSelectedFunc(func(index int, _ string, _ string, _ rune) {
+		ui.handleSimilarArtistSelected(index)
+	})
+
+	ui.similarArtistList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch keyName(event) {
+		case keybind("left"):
+			ui.app.SetFocus(ui.entityList)
+			return nil
+		case keybind("playTopSongs"):
+			index := ui.similarArtistList.GetCurrentItem()
+			if index >= 0 && index < len(ui.similarArtists) {
+				ui.handlePlayTopSongs(ui.similarArtists[index].Name)
+			}
+			return nil
+		}
+		return event
+	})
+
+	ui.artistInfoFlex = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(ui.artistBio, 0, 2, false).
+		AddItem(ui.similarArtistList, 0, 1, false)
+
+	return ui.artistInfoFlex
+}
+
+// handleArtistHighlighted loads the info pane for the artist at index in the
+// artist list. Info is fetched in the background and cached for the session.
+func (ui *Ui) handleArtistHighlighted(index int) {
+	if !ui.showArtistInfo || index < 0 || index >= len(ui.artistIdList) {
+		return
+	}
+
+	id := ui.artistIdList[index]
+	ui.artistInfoId = id
+	if info, present := ui.artistInfoCache[id]; present {
+		ui.setArtistInfo(info)
+		return
+	}
+
+	ui.artistBio.SetText("[::d]loading…")
+	ui.similarArtistList.Clear()
+	ui.similarArtists = nil
+
+	connection := ui.connection
+	go func() {
+		response, err := connection.GetArtistInfo(ui.ctx, id)
+		if err != nil {
+			connection.Logger.Printf("handleArtistHighlighted: GetArtistInfo %s -- %s", id, err.Error())
+		}
+
+		ui.app.QueueUpdateDraw(func() {
+			if ui.connection != connection {
+				return
+			}
+			if err != nil {
+				// not cached, so highlighting the artist again tries again
+				if ui.artistInfoId == id {
+					ui.artistBio.SetText("[::d]no artist info: " + tview.Escape(describeError(err)))
+				}
+				return
+			}
+			ui.artistInfoCache[id] = response.ArtistInfo
+			// the selection may have moved on while we were waiting
+			if ui.artistInfoId == id {
+				ui.setArtistInfo(response.ArtistInfo)
+			}
+		})
+	}()
+}
+
+func (ui *Ui) setArtistInfo(info SubsonicArtistInfo) {
+	bio := strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(info.Biography, "")))
+	if bio == "" {
+		bio = "[::d]no biography"
+	} else {
+		bio = tview.Escape(bio)
+	}
+	ui.artistBio.SetText(bio).ScrollToBeginning()
+
+	ui.similarArtists = info.SimilarArtists
+	ui.similarArtistList.Clear()
+	for _, artist := range info.SimilarArtists {
+		text := artist.Name
+		// similar artists without an id aren't in the library
+		if artist.Id == "" {
+			text = "[::d]" + tview.Escape(artist.Name)
+		}
+		ui.similarArtistList.AddItem(text, "", 0, nil)
+	}
+}
+
+// toggleArtistInfo shows or hides the info pane in the browser
+func (ui *Ui) toggleArtistInfo() {
+	ui.showArtistInfo = !ui.showArtistInfo
+	if ui.showArtistInfo {
+		ui.browserColumns.AddItem(ui.artistInfoFlex, 0, 1, false)
+		ui.handleArtistHighlighted(ui.artistList.GetCurrentItem())
+	} else {
+		ui.browserColumns.RemoveItem(ui.artistInfoFlex)
+		if ui.app.GetFocus() == ui.similarArtistList {
+			ui.app.SetFocus(ui.entityList)
+		}
+	}
+}
+
+// handleSimilarArtistSelected jumps to a similar artist in the artist list
+func (ui *Ui) handleSimilarArtistSelected(index int) {
+	if index < 0 || index >= len(ui.similarArtists) {
+		return
+	}
+	similar := ui.similarArtists[index]
+
+	for i, id := range ui.artistIdList {
+		if (similar.Id != "" && id == similar.Id) || ui.artistNameList[i] == similar.Name {
+			ui.artistList.SetCurrentItem(i)
+			ui.app.SetFocus(ui.artistList)
+			return
+		}
+	}
+	ui.connection.Logger.Printf("handleSimilarArtistSelected: %s is not in the library", similar.Name)
+}
+
+// handlePlayTopSongs replaces the queue with an artist's top songs
+func (ui *Ui) handlePlayTopSongs(artist string) {
+	ui.enqueueInBackground("handlePlayTopSongs: GetTopSongs "+artist, true, func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error) {
+		response, err := connection.GetTopSongs(ctx, artist)
+		if err != nil {
+			return nil, err
+		}
+		return response.TopSongs.Song, nil
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// shown at the right of the title row while requests run in the background
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+const spinnerInterval = 100 * time.Millisecond
+
+// runInBackground runs work off the UI goroutine, so a slow server doesn't
+// freeze input. work must not touch the UI; the function it returns is run on
+// the UI goroutine to apply the result, unless ctx was cancelled meanwhile.
+func (ui *Ui) runInBackground(ctx context.Context, work func(ctx context.Context) func()) {
+	ui.startLoading()
+	go func() {
+		apply := work(ctx)
+		ui.app.QueueUpdateDraw(func() {
+			ui.stopLoading()
+			if apply != nil && ctx.Err() == nil {
+				apply()
+			}
+		})
+	}()
+}
+
+// enqueueInBackground fetches songs off the UI goroutine, through the current
+// connection, and adds them to the queue. With replace the queue is replaced
+// and playback starts from the top.
+func (ui *Ui) enqueueInBackground(caller string, replace bool, fetch func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error)) {
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		songs, err := fetch(ctx, connection)
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, caller)
+				return
+			}
+			// songs of another server don't belong in the queue
+			if ui.connection != connection {
+				return
+			}
+			if replace && len(songs) == 0 {
+				ui.connection.Logger.Printf("%s: nothing to play", caller)
+				return
+			}
+
+			if replace {
+				ui.player.ReplaceQueue(nil)
+			}
+			for i := range songs {
+				ui.addSongToQueue(&songs[i])
+			}
+			if replace {
+				if err := ui.player.PlayQueueIndex(0); err != nil {
+					ui.connection.Logger.Printf("%s: PlayQueueIndex -- %s", caller, err.Error())
+				}
+			}
+			updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
+		}
+	})
+}
+
+// startLoading and stopLoading count requests in flight; the spinner runs
+// while there are any. Both must be called on the UI goroutine.
+func (ui *Ui) startLoading() {
+	ui.loading++
+	if ui.loading > 1 {
+		return
+	}
+
+	stop := make(chan struct{})
+	ui.spinnerStop = stop
+	go func() {
+		ticker := time.NewTicker(spinnerInterval)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			text := spinnerFrames[frame%len(spinnerFrames)]
+			ui.app.QueueUpdateDraw(func() {
+				if ui.loading > 0 {
+					ui.spinner.SetText(text)
+				}
+			})
+		}
+	}()
+}
+
+func (ui *Ui) stopLoading() {
+	ui.loading--
+	if ui.loading > 0 {
+		return
+	}
+	close(ui.spinnerStop)
+	ui.spinner.SetText("")
+}
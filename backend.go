@@ -0,0 +1,83 @@
+package main
+
+import "fmt"
+
+// Backend does the actual playing for Player. Implementations have to be safe
+// to call from several goroutines.
+type Backend interface {
+	// Load stops whatever is playing and starts playing uri
+	Load(uri string) error
+	Stop() error
+	SetPaused(paused bool) error
+	Paused() (bool, error)
+	// Idle reports whether nothing is loaded
+	Idle() (bool, error)
+	// Seek moves the playback position by seconds, backwards if negative
+	Seek(seconds int) error
+	Position() (float64, error)
+	Duration() (float64, error)
+	// Volume is in percent, 0-100
+	Volume() (int64, error)
+	SetVolume(volume int64) error
+	// SetHeaders sets extra HTTP headers, as "Name: value", sent when loading
+	// files from now on
+	SetHeaders(headers []string) error
+	// Events delivers what the backend is doing, see BackendEvent. It is
+	// closed once the backend has shut down.
+	Events() <-chan BackendEvent
+	Close() error
+}
+
+type BackendEventType int
+
+const (
+	BackendFileStarted BackendEventType = iota
+	BackendFileEnded
+	BackendSeeked
+	BackendPositionChanged
+	BackendDurationChanged
+	BackendVolumeChanged
+	BackendPauseChanged
+)
+
+// BackendEvent is a raw event from a Backend, Player turns these into
+// PlayerEvents. Only the fields that make sense for Type are set.
+type BackendEvent struct {
+	Type BackendEventType
+	// FileEnded: the file played to the end, rather than being stopped or
+	// replaced
+	EOF bool
+	// FileEnded: the file couldn't be played
+	Failed bool
+	// PositionChanged, in seconds
+	Position float64
+	// DurationChanged, in seconds
+	Duration float64
+	// VolumeChanged
+	Volume int64
+	// PauseChanged
+	Paused bool
+}
+
+// backend names for the player.backend setting
+const (
+	BackendLibmpv = "libmpv"
+	BackendIPC    = "ipc"
+)
+
+// newBackend starts the backend named by the player.backend setting. An empty
+// name picks libmpv if stmp was built with it, mpv over IPC otherwise.
+func newBackend(name string, mpvPath string) (Backend, error) {
+	if name == "" {
+		name = defaultBackend
+	}
+
+	switch name {
+	case BackendLibmpv:
+		return newLibmpvBackend()
+	case BackendIPC:
+		return newIPCBackend(mpvPath)
+	}
+	return nil, fmt.Errorf("unknown player backend %q, expected %s or %s",
+		name, BackendLibmpv, BackendIPC)
+}
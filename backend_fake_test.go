@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// FakeBackend plays nothing. It keeps the state a real backend would, so
+// tests can exercise the player without mpv, and lets them drive playback.
+type FakeBackend struct {
+	lock     sync.Mutex
+	loaded   []string
+	current  string
+	paused   bool
+	position float64
+	volume   int64
+	headers  []string
+	events   chan BackendEvent
+	closed   bool
+	// emit waits for each event to be read
+	unbuffered bool
+}
+
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		volume: 100,
+		// roomy enough that tests needn't read events to make progress
+		events: make(chan BackendEvent, 1024),
+	}
+}
+
+// NewUnbufferedFakeBackend returns a backend that waits for each event to be
+// read before it goes on, as a real one does once it is far enough ahead
+func NewUnbufferedFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		volume:     100,
+		events:     make(chan BackendEvent),
+		unbuffered: true,
+	}
+}
+
+// emit expects lock to be held
+func (b *FakeBackend) emit(event BackendEvent) {
+	if b.closed {
+		return
+	}
+	if b.unbuffered {
+		b.events <- event
+		return
+	}
+	select {
+	case b.events <- event:
+	default:
+	}
+}
+
+// Progress moves playback on to position, in seconds, as playing would
+func (b *FakeBackend) Progress(position float64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.position = position
+	b.emit(BackendEvent{Type: BackendPositionChanged, Position: position})
+}
+
+// Loaded returns every uri loaded so far, oldest first
+func (b *FakeBackend) Loaded() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]string(nil), b.loaded...)
+}
+
+// Finish ends the current file as if it played to the end
+func (b *FakeBackend) Finish() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.current == "" {
+		return
+	}
+	b.current = ""
+	b.position = 0
+	b.emit(BackendEvent{Type: BackendFileEnded, EOF: true})
+}
+
+func (b *FakeBackend) Load(uri string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return errors.New("backend is closed")
+	}
+	if b.current != "" {
+		b.emit(BackendEvent{Type: BackendFileEnded})
+	}
+	b.current = uri
+	b.position = 0
+	b.loaded = append(b.loaded, uri)
+	b.emit(BackendEvent{Type: BackendFileStarted})
+	return nil
+}
+
+func (b *FakeBackend) Stop() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.current != "" {
+		b.current = ""
+		b.position = 0
+		b.emit(BackendEvent{Type: BackendFileEnded})
+	}
+	return nil
+}
+
+func (b *FakeBackend) SetPaused(paused bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.paused != paused {
+		b.paused = paused
+		b.emit(BackendEvent{Type: BackendPauseChanged, Paused: paused})
+	}
+	return nil
+}
+
+func (b *FakeBackend) Paused() (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.paused, nil
+}
+
+func (b *FakeBackend) Idle() (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.current == "", nil
+}
+
+func (b *FakeBackend) Seek(seconds int) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.position += float64(seconds)
+	if b.position < 0 {
+		b.position = 0
+	}
+	b.emit(BackendEvent{Type: BackendSeeked})
+	b.emit(BackendEvent{Type: BackendPositionChanged, Position: b.position})
+	return nil
+}
+
+func (b *FakeBackend) Position() (float64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.position, nil
+}
+
+func (b *FakeBackend) Duration() (float64, error) {
+	return 0, nil
+}
+
+func (b *FakeBackend) Volume() (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.volume, nil
+}
+
+func (b *FakeBackend) SetVolume(volume int64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.volume = volume
+	b.emit(BackendEvent{Type: BackendVolumeChanged, Volume: volume})
+	return nil
+}
+
+func (b *FakeBackend) SetHeaders(headers []string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.headers = append([]string(nil), headers...)
+	return nil
+}
+
+// Headers returns what SetHeaders was last called with
+func (b *FakeBackend) Headers() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]string(nil), b.headers...)
+}
+
+func (b *FakeBackend) Events() <-chan BackendEvent {
+	return b.events
+}
+
+func (b *FakeBackend) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.events)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how long to wait for mpv to come up, and for it to answer a command
+const (
+	ipcStartTimeout   = 5 * time.Second
+	ipcCommandTimeout = 5 * time.Second
+)
+
+// ipcBackend runs mpv as a child process and drives it over its JSON IPC
+// socket, see https://mpv.io/manual/stable/#json-ipc. Unlike libmpv it needs
+// no cgo, only an mpv binary.
+type ipcBackend struct {
+	cmd        *exec.Cmd
+	conn       net.Conn
+	socketPath string
+
+	writeLock sync.Mutex
+	// replies are matched to commands by request_id
+	pendingLock sync.Mutex
+	pending     map[int64]chan ipcMessage
+	nextId      int64
+
+	events    chan BackendEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ipcMessage is anything mpv writes to the socket: a reply to a command, or
+// an event
+type ipcMessage struct {
+	RequestId *int64          `json:"request_id"`
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+	Event     string          `json:"event"`
+	Id        int64           `json:"id"`
+	Name      string          `json:"name"`
+	Reason    string          `json:"reason"`
+}
+
+// ids for observe_property, they come back in property-change events
+var ipcObservedProperties = []string{"time-pos", "duration", "volume", "pause"}
+
+func newIPCBackend(mpvPath string) (Backend, error) {
+	if mpvPath == "" {
+		mpvPath = "mpv"
+	}
+
+	socketPath := filepath.Join(os.TempDir(), fmt.Sprintf("stmp-mpv-%d.sock", os.Getpid()))
+	os.Remove(socketPath)
+
+	cmd := exec.Command(mpvPath,
+		"--idle=yes",
+		"--no-video",
+		"--no-terminal",
+		"--audio-display=no",
+		"--input-ipc-server="+socketPath)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting %s: %w", mpvPath, err)
+	}
+
+	// mpv creates the socket once it's initialised
+	var conn net.Conn
+	var err error
+	deadline := time.Now().Add(ipcStartTimeout)
+	for {
+		conn, err = net.Dial("unix", socketPath)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, fmt.Errorf("connecting to mpv: %w", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	b := &ipcBackend{
+		cmd:        cmd,
+		conn:       conn,
+		socketPath: socketPath,
+		pending:    make(map[int64]chan ipcMessage),
+		events:     make(chan BackendEvent, eventBuffer),
+		done:       make(chan struct{}),
+	}
+	go b.read()
+
+	for i, name := range ipcObservedProperties {
+		if _, err := b.command("observe_property", i+1, name); err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// read handles everything mpv sends until the connection closes
+func (b *ipcBackend) read() {
+	defer close(b.events)
+
+	scanner := bufio.NewScanner(b.conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var message ipcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			continue
+		}
+
+		if message.Event == "" {
+			if message.RequestId == nil {
+				continue
+			}
+			b.pendingLock.Lock()
+			reply, ok := b.pending[*message.RequestId]
+			delete(b.pending, *message.RequestId)
+			b.pendingLock.Unlock()
+			if ok {
+				reply <- message
+			}
+			continue
+		}
+
+		event, ok := ipcEvent(message)
+		if !ok {
+			continue
+		}
+		select {
+		case b.events <- event:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func ipcEvent(message ipcMessage) (BackendEvent, bool) {
+	switch message.Event {
+	case "start-file":
+		return BackendEvent{Type: BackendFileStarted}, true
+	case "end-file":
+		return BackendEvent{Type: BackendFileEnded, EOF: message.Reason == "eof", Failed: message.Reason == "error"}, true
+	case "seek":
+		return BackendEvent{Type: BackendSeeked}, true
+	case "property-change":
+		// data is missing or null while the property is unavailable
+		switch message.Name {
+		case "time-pos":
+			var position float64
+			json.Unmarshal(message.Data, &position)
+			return BackendEvent{Type: BackendPositionChanged, Position: position}, true
+		case "duration":
+			var duration float64
+			json.Unmarshal(message.Data, &duration)
+			return BackendEvent{Type: BackendDurationChanged, Duration: duration}, true
+		case "volume":
+			var volume float64
+			if json.Unmarshal(message.Data, &volume) != nil {
+				return BackendEvent{}, false
+			}
+			return BackendEvent{Type: BackendVolumeChanged, Volume: int64(volume)}, true
+		case "pause":
+			var paused bool
+			if json.Unmarshal(message.Data, &paused) != nil {
+				return BackendEvent{}, false
+			}
+			return BackendEvent{Type: BackendPauseChanged, Paused: paused}, true
+		}
+	}
+	return BackendEvent{}, false
+}
+
+// command sends a command to mpv and waits for its reply
+func (b *ipcBackend) command(args ...interface{}) (json.RawMessage, error) {
+	b.pendingLock.Lock()
+	b.nextId++
+	id := b.nextId
+	reply := make(chan ipcMessage, 1)
+	b.pending[id] = reply
+	b.pendingLock.Unlock()
+
+	request, err := json.Marshal(map[string]interface{}{"command": args, "request_id": id})
+	if err != nil {
+		return nil, err
+	}
+
+	b.writeLock.Lock()
+	_, err = b.conn.Write(append(request, '\n'))
+	b.writeLock.Unlock()
+
+	if err == nil {
+		timer := time.NewTimer(ipcCommandTimeout)
+		defer timer.Stop()
+		select {
+		case message := <-reply:
+			if message.Error != "success" {
+				return nil, fmt.Errorf("mpv %v: %s", args[0], message.Error)
+			}
+			return message.Data, nil
+		case <-b.done:
+			err = errors.New("mpv has been closed")
+		case <-timer.C:
+			err = fmt.Errorf("mpv %v: no reply", args[0])
+		}
+	}
+
+	b.pendingLock.Lock()
+	delete(b.pending, id)
+	b.pendingLock.Unlock()
+	return nil, err
+}
+
+func (b *ipcBackend) getProperty(name string, value interface{}) error {
+	data, err := b.command("get_property", name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (b *ipcBackend) Load(uri string) error {
+	_, err := b.command("loadfile", uri)
+	return err
+}
+
+func (b *ipcBackend) Stop() error {
+	_, err := b.command("stop")
+	return err
+}
+
+func (b *ipcBackend) SetPaused(paused bool) error {
+	_, err := b.command("set_property", "pause", paused)
+	return err
+}
+
+func (b *ipcBackend) Paused() (bool, error) {
+	var paused bool
+	err := b.getProperty("pause", &paused)
+	return paused, err
+}
+
+func (b *ipcBackend) Idle() (bool, error) {
+	var idle bool
+	err := b.getProperty("idle-active", &idle)
+	return idle, err
+}
+
+func (b *ipcBackend) Seek(seconds int) error {
+	_, err := b.command("seek", strconv.Itoa(seconds))
+	return err
+}
+
+// Position and Duration are 0 while nothing is loaded
+func (b *ipcBackend) Position() (float64, error) {
+	var position float64
+	b.getProperty("time-pos", &position)
+	return position, nil
+}
+
+func (b *ipcBackend) Duration() (float64, error) {
+	var duration float64
+	b.getProperty("duration", &duration)
+	return duration, nil
+}
+
+func (b *ipcBackend) Volume() (int64, error) {
+	var volume float64
+	if err := b.getProperty("volume", &volume); err != nil {
+		return -1, err
+	}
+	return int64(volume), nil
+}
+
+func (b *ipcBackend) SetVolume(volume int64) error {
+	_, err := b.command("set_property", "volume", volume)
+	return err
+}
+
+func (b *ipcBackend) SetHeaders(headers []string) error {
+	if headers == nil {
+		headers = []string{}
+	}
+	_, err := b.command("set_property", "http-header-fields", headers)
+	return err
+}
+
+func (b *ipcBackend) Events() <-chan BackendEvent {
+	return b.events
+}
+
+// Close asks mpv to quit, and kills it if it doesn't
+func (b *ipcBackend) Close() error {
+	b.closeOnce.Do(func() {
+		b.writeLock.Lock()
+		b.conn.Write([]byte(`{"command": ["quit"]}` + "\n"))
+		b.writeLock.Unlock()
+		close(b.done)
+		b.conn.Close()
+
+		exited := make(chan struct{})
+		go func() {
+			b.cmd.Wait()
+			close(exited)
+		}()
+		select {
+		case <-exited:
+		case <-time.After(ipcStartTimeout):
+			b.cmd.Process.Kill()
+			<-exited
+		}
+		os.Remove(b.socketPath)
+	})
+	return nil
+}
@@ -0,0 +1,209 @@
+//go:build !nolibmpv
+// +build !nolibmpv
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wildeyedskies/go-mpv/mpv"
+)
+
+const defaultBackend = BackendLibmpv
+
+// libmpvBackend plays through libmpv, linked in with cgo. Build with
+// -tags nolibmpv to leave it out.
+type libmpvBackend struct {
+	instance  *mpv.Mpv
+	events    chan BackendEvent
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// userdata passed to ObserveProperty, telling property change events apart
+const (
+	observeTimePos = iota + 1
+	observeDuration
+	observeVolume
+	observePause
+)
+
+func newLibmpvBackend() (Backend, error) {
+	instance := mpv.Create()
+
+	// TODO figure out what other mpv options we need
+	instance.SetOptionString("audio-display", "no")
+	instance.SetOptionString("video", "no")
+
+	err := instance.Initialize()
+	if err != nil {
+		instance.TerminateDestroy()
+		return nil, err
+	}
+
+	instance.ObserveProperty(observeTimePos, "time-pos", mpv.FORMAT_DOUBLE)
+	instance.ObserveProperty(observeDuration, "duration", mpv.FORMAT_DOUBLE)
+	instance.ObserveProperty(observeVolume, "volume", mpv.FORMAT_INT64)
+	instance.ObserveProperty(observePause, "pause", mpv.FORMAT_FLAG)
+
+	b := &libmpvBackend{
+		instance: instance,
+		events:   make(chan BackendEvent, eventBuffer),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go b.listen()
+	return b, nil
+}
+
+// listen turns mpv events into backend events until Close
+func (b *libmpvBackend) listen() {
+	defer close(b.stopped)
+	defer close(b.events)
+
+	for {
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+
+		e := b.instance.WaitEvent(1)
+		if e == nil || e.Event_Id == mpv.EVENT_SHUTDOWN {
+			return
+		}
+
+		var event BackendEvent
+		switch e.Event_Id {
+		case mpv.EVENT_START_FILE:
+			event = BackendEvent{Type: BackendFileStarted}
+		case mpv.EVENT_END_FILE:
+			event = BackendEvent{Type: BackendFileEnded}
+			if endFile, ok := e.Data.(mpv.EventEndFile); ok {
+				event.EOF = endFile.Reason == mpv.END_FILE_REASON_EOF
+				event.Failed = endFile.Reason == mpv.END_FILE_REASON_ERROR
+			}
+		case mpv.EVENT_SEEK:
+			event = BackendEvent{Type: BackendSeeked}
+		case mpv.EVENT_PROPERTY_CHANGE:
+			switch e.Reply_Userdata {
+			case observeTimePos:
+				position, _ := b.Position()
+				event = BackendEvent{Type: BackendPositionChanged, Position: position}
+			case observeDuration:
+				duration, _ := b.Duration()
+				event = BackendEvent{Type: BackendDurationChanged, Duration: duration}
+			case observeVolume:
+				volume, err := b.Volume()
+				if err != nil {
+					continue
+				}
+				event = BackendEvent{Type: BackendVolumeChanged, Volume: volume}
+			case observePause:
+				paused, err := b.Paused()
+				if err != nil {
+					continue
+				}
+				event = BackendEvent{Type: BackendPauseChanged, Paused: paused}
+			default:
+				continue
+			}
+		default:
+			continue
+		}
+
+		select {
+		case b.events <- event:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *libmpvBackend) Load(uri string) error {
+	return b.instance.Command([]string{"loadfile", uri})
+}
+
+func (b *libmpvBackend) Stop() error {
+	return b.instance.Command([]string{"stop"})
+}
+
+func (b *libmpvBackend) SetPaused(paused bool) error {
+	return b.instance.SetProperty("pause", mpv.FORMAT_FLAG, paused)
+}
+
+func (b *libmpvBackend) Paused() (bool, error) {
+	pause, err := b.instance.GetProperty("pause", mpv.FORMAT_FLAG)
+	if err != nil || pause == nil {
+		return false, err
+	}
+	return pause.(bool), nil
+}
+
+func (b *libmpvBackend) Idle() (bool, error) {
+	idle, err := b.instance.GetProperty("idle-active", mpv.FORMAT_FLAG)
+	if err != nil || idle == nil {
+		return true, err
+	}
+	return idle.(bool), nil
+}
+
+func (b *libmpvBackend) Seek(seconds int) error {
+	return b.instance.Command([]string{"seek", strconv.Itoa(seconds)})
+}
+
+// Position and Duration are 0 while nothing is loaded
+func (b *libmpvBackend) Position() (float64, error) {
+	return b.floatProperty("time-pos")
+}
+
+func (b *libmpvBackend) Duration() (float64, error) {
+	return b.floatProperty("duration")
+}
+
+func (b *libmpvBackend) floatProperty(name string) (float64, error) {
+	value, err := b.instance.GetProperty(name, mpv.FORMAT_DOUBLE)
+	if err != nil || value == nil {
+		return 0, nil
+	}
+	return value.(float64), nil
+}
+
+func (b *libmpvBackend) Volume() (int64, error) {
+	volume, err := b.instance.GetProperty("volume", mpv.FORMAT_INT64)
+	if err != nil {
+		return -1, err
+	}
+	if volume == nil {
+		return 0, nil
+	}
+	return volume.(int64), nil
+}
+
+func (b *libmpvBackend) SetVolume(volume int64) error {
+	return b.instance.SetProperty("volume", mpv.FORMAT_INT64, volume)
+}
+
+func (b *libmpvBackend) SetHeaders(headers []string) error {
+	// mpv splits the list on commas
+	return b.instance.SetPropertyString("http-header-fields", strings.Join(headers, ","))
+}
+
+func (b *libmpvBackend) Events() <-chan BackendEvent {
+	return b.events
+}
+
+// Close stops the event loop before destroying mpv, so it never waits on a
+// destroyed handle
+func (b *libmpvBackend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.instance.Wakeup()
+		<-b.stopped
+		b.instance.TerminateDestroy()
+	})
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build nolibmpv
+// +build nolibmpv
+
+package main
+
+import "errors"
+
+const defaultBackend = BackendIPC
+
+func newLibmpvBackend() (Backend, error) {
+	return nil, errors.New("stmp was built without libmpv, use player.backend = 'ipc'")
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ways of putting an image on the terminal, see detectGraphicsProtocol
+const (
+	GraphicsKitty     = "kitty"
+	GraphicsSixel     = "sixel"
+	GraphicsHalfBlock = "halfblock"
+	GraphicsNone      = "none"
+)
+
+// detectGraphicsProtocol turns the ui.coverArt setting into a protocol. Any
+// explicit protocol is used as is, "auto" guesses from the environment since
+// querying the terminal would race with tcell reading input.
+func detectGraphicsProtocol(setting string) string {
+	switch setting {
+	case GraphicsKitty, GraphicsSixel, GraphicsHalfBlock, GraphicsNone:
+		return setting
+	}
+
+	term := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+	if os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(term, "kitty") ||
+		strings.Contains(term, "ghostty") || termProgram == "WezTerm" {
+		return GraphicsKitty
+	}
+	for _, sixelTerm := range []string{"foot", "mlterm", "yaft", "contour"} {
+		if strings.HasPrefix(term, sixelTerm) {
+			return GraphicsSixel
+		}
+	}
+	return GraphicsHalfBlock
+}
+
+// CoverArtView is a tview primitive that shows an album cover. Half blocks are
+// drawn like any other primitive. Kitty and sixel images bypass tcell, so Draw
+// only reserves the area and Flush writes the image once tcell is done.
+type CoverArtView struct {
+	*tview.Box
+	image      image.Image
+	protocol   string
+	cellWidth  int
+	cellHeight int
+	tty        io.Writer
+
+	// where the image should go this frame, in cells, and what was actually
+	// written to the terminal last time
+	drawn      bool
+	placement  [4]int
+	shownImage image.Image
+	shownAt    [4]int
+}
+
+func NewCoverArtView(protocol string, cellWidth int, cellHeight int) *CoverArtView {
+	var tty io.Writer = os.Stdout
+	if protocol == GraphicsKitty || protocol == GraphicsSixel {
+		// tcell writes to /dev/tty as well, so ours ends up in the same place
+		if f, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+			tty = f
+		}
+	}
+	if cellWidth <= 0 {
+		cellWidth = 10
+	}
+	if cellHeight <= 0 {
+		cellHeight = 20
+	}
+
+	return &CoverArtView{
+		Box:        tview.NewBox(),
+		protocol:   protocol,
+		cellWidth:  cellWidth,
+		cellHeight: cellHeight,
+		tty:        tty,
+	}
+}
+
+// SetImage replaces the shown image, nil clears it
+func (c *CoverArtView) SetImage(img image.Image) *CoverArtView {
+	c.image = img
+	return c
+}
+
+func (c *CoverArtView) Draw(screen tcell.Screen) {
+	c.Box.DrawForSubclass(screen, c)
+	x, y, width, height := c.GetInnerRect()
+	if c.image == nil || width <= 0 || height <= 0 || c.protocol == GraphicsNone {
+		return
+	}
+
+	bounds := c.image.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return
+	}
+
+	if c.protocol == GraphicsHalfBlock {
+		c.drawHalfBlocks(screen, x, y, width, height)
+		return
+	}
+
+	// fit the image in the box using the pixel size of a cell, then centre it
+	scale := minFloat(float64(width*c.cellWidth)/float64(bounds.Dx()),
+		float64(height*c.cellHeight)/float64(bounds.Dy()))
+	cols := int(float64(bounds.Dx())*scale) / c.cellWidth
+	rows := int(float64(bounds.Dy())*scale) / c.cellHeight
+	if cols <= 0 || rows <= 0 {
+		return
+	}
+
+	// blank the cells under the image so tcell leaves them alone
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			screen.SetContent(x+col, y+row, ' ', nil, tcell.StyleDefault)
+		}
+	}
+
+	c.drawn = true
+	c.placement = [4]int{x + (width-cols)/2, y + (height-rows)/2, cols, rows}
+}
+
+// drawHalfBlocks draws two pixels per cell, using the upper half block with
+// the top pixel as foreground and the bottom one as background
+func (c *CoverArtView) drawHalfBlocks(screen tcell.Screen, x, y, width, height int) {
+	bounds := c.image.Bounds()
+	pixelWidth, pixelHeight := width, height*2
+	if float64(pixelWidth)/float64(pixelHeight) > float64(bounds.Dx())/float64(bounds.Dy()) {
+		pixelWidth = pixelHeight * bounds.Dx() / bounds.Dy()
+	} else {
+		pixelHeight = pixelWidth * bounds.Dy() / bounds.Dx()
+	}
+	if pixelWidth <= 0 || pixelHeight <= 0 {
+		return
+	}
+
+	scaled := scaleImage(c.image, pixelWidth, pixelHeight)
+	left := x + (width-pixelWidth)/2
+	top := y + (height-(pixelHeight+1)/2)/2
+	for row := 0; row*2 < pixelHeight; row++ {
+		for col := 0; col < pixelWidth; col++ {
+			style := tcell.StyleDefault.Foreground(tcellColor(scaled.RGBAAt(col, row*2)))
+			if row*2+1 < pixelHeight {
+				style = style.Background(tcellColor(scaled.RGBAAt(col, row*2+1)))
+			}
+			screen.SetContent(left+col, top+row, '▀', nil, style)
+		}
+	}
+}
+
+// Flush writes kitty or sixel images to the terminal. It has to run after
+// tview has drawn everything, see Application.SetAfterDrawFunc.
+func (c *CoverArtView) Flush(screen tcell.Screen) {
+	if c.protocol != GraphicsKitty && c.protocol != GraphicsSixel {
+		return
+	}
+
+	drawn := c.drawn
+	c.drawn = false
+
+	if !drawn {
+		if c.shownImage != nil {
+			// the page was hidden or the image cleared
+			c.clearTerminalImage(screen)
+			c.shownImage = nil
+		}
+		return
+	}
+
+	if c.shownImage == c.image && c.shownAt == c.placement {
+		return
+	}
+	if c.shownImage != nil {
+		c.clearTerminalImage(screen)
+	}
+
+	// make tcell write out the blanked area before we draw on top of it
+	screen.Show()
+
+	var buf bytes.Buffer
+	// save the cursor, move to the top left corner of the placement
+	fmt.Fprintf(&buf, "\x1b7\x1b[%d;%dH", c.placement[1]+1, c.placement[0]+1)
+	if c.protocol == GraphicsKitty {
+		writeKittyImage(&buf, c.image, c.placement[2], c.placement[3])
+	} else {
+		scaled := scaleImage(c.image, c.placement[2]*c.cellWidth, c.placement[3]*c.cellHeight)
+		writeSixelImage(&buf, scaled)
+	}
+	buf.WriteString("\x1b8")
+	c.tty.Write(buf.Bytes())
+
+	c.shownImage = c.image
+	c.shownAt = c.placement
+}
+
+func (c *CoverArtView) clearTerminalImage(screen tcell.Screen) {
+	if c.protocol == GraphicsKitty {
+		io.WriteString(c.tty, "\x1b_Ga=d,d=I,i=1,q=2\x1b\\")
+		return
+	}
+	// sixels are plain pixels on the terminal, the only way to get rid of
+	// them is to repaint every cell
+	screen.Sync()
+}
+
+// writeKittyImage transmits img as a png and places it over cols x rows cells
+// at the cursor. See https://sw.kovidgoyal.net/kitty/graphics-protocol/
+func writeKittyImage(w io.Writer, img image.Image, cols, rows int) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return
+	}
+	payload := base64.StdEncoding.EncodeToString(encoded.Bytes())
+
+	// the payload has to be sent in chunks of at most 4096 bytes
+	const chunkSize = 4096
+	for start := 0; start < len(payload); start += chunkSize {
+		end := start + chunkSize
+		more := 1
+		if end >= len(payload) {
+			end = len(payload)
+			more = 0
+		}
+		if start == 0 {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,i=1,c=%d,r=%d,C=1,q=2,m=%d;%s\x1b\\", cols, rows, more, payload[start:end])
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, payload[start:end])
+		}
+	}
+}
+
+// writeSixelImage encodes img as sixels using a 6x6x6 colour cube
+func writeSixelImage(w io.Writer, img *image.RGBA) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\x1bP0;1;0q\"1;1;%d;%d", width, height)
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&buf, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+
+	indexes := make([]int, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := img.RGBAAt(x, y)
+			indexes[y*width+x] = int(p.R)*6/256*36 + int(p.G)*6/256*6 + int(p.B)*6/256
+		}
+	}
+
+	// each sixel row is a band of 6 pixel rows, drawn once per colour in it
+	for band := 0; band < height; band += 6 {
+		used := map[int]bool{}
+		for y := band; y < band+6 && y < height; y++ {
+			for x := 0; x < width; x++ {
+				used[indexes[y*width+x]] = true
+			}
+		}
+
+		first := true
+		for colour := range used {
+			if !first {
+				buf.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(&buf, "#%d", colour)
+
+			var last byte
+			run := 0
+			for x := 0; x < width; x++ {
+				var bits byte
+				for bit := 0; bit < 6 && band+bit < height; bit++ {
+					if indexes[(band+bit)*width+x] == colour {
+						bits |= 1 << uint(bit)
+					}
+				}
+				if run > 0 && bits+63 != last {
+					writeSixelRun(&buf, last, run)
+					run = 0
+				}
+				last = bits + 63
+				run++
+			}
+			writeSixelRun(&buf, last, run)
+		}
+		buf.WriteByte('-')
+	}
+	buf.WriteString("\x1b\\")
+	w.Write(buf.Bytes())
+}
+
+func writeSixelRun(buf *bytes.Buffer, sixel byte, run int) {
+	if run > 3 {
+		fmt.Fprintf(buf, "!%d%c", run, sixel)
+		return
+	}
+	for i := 0; i < run; i++ {
+		buf.WriteByte(sixel)
+	}
+}
+
+// scaleImage resizes src to width x height, averaging the source pixels that
+// fall in each destination pixel
+func scaleImage(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, _ := src.At(sx, sy).RGBA()
+					r, g, b, n = r+pr>>8, g+pg>>8, b+pb>>8, n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255})
+		}
+	}
+	return dst
+}
+
+func tcellColor(c color.RGBA) tcell.Color {
+	return tcell.NewRGBColor(int32(c.R), int32(c.G), int32(c.B))
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
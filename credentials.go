@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// shared by the prompts, so neither loses input buffered by the other
+var stdin = bufio.NewReader(os.Stdin)
+
+// resolvePassword finds the password for username on host, trying in turn
+// auth.password, the output of auth.passwordCommand and the keyring. If none
+// has it, interactive is set and stdin is a terminal, the user is asked for
+// it, and may have it stored in the keyring for next time.
+func resolvePassword(host, username, password, passwordCommand string, interactive bool) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+	if passwordCommand != "" {
+		return commandPassword(passwordCommand, interactive)
+	}
+
+	password, err := keyringPassword(host, username)
+	if err == nil {
+		return password, nil
+	}
+	if !interactive || !isTerminal(os.Stdin) {
+		if errors.Is(err, ErrNoSecret) {
+			return "", fmt.Errorf("no password for %s in the keyring, run stmp in a terminal to add it", username)
+		}
+		return "", fmt.Errorf("reading the keyring: %w", err)
+	}
+	keyringErr := err
+
+	password, err = promptPassword(fmt.Sprintf("Password for %s on %s: ", username, host))
+	if err != nil {
+		return "", err
+	}
+
+	// a keyring that can't be read can't be written either
+	if errors.Is(keyringErr, ErrNoSecret) && confirm("Save the password in the keyring? [Y/n] ") {
+		if err := storeKeyringPassword(host, username, password); err != nil {
+			fmt.Printf("Unable to save the password: %s\n", err)
+		}
+	}
+	return password, nil
+}
+
+// commandPassword runs command with the shell and returns the first line it
+// prints, which is how pass and similar tools print passwords. Unless
+// interactive, the command doesn't get the terminal, which belongs to the UI.
+func commandPassword(command string, interactive bool) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	if interactive {
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("auth.passwordCommand: %w", err)
+	}
+
+	line := string(output)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimRight(line, "\r")
+	if line == "" {
+		return "", errors.New("auth.passwordCommand printed no password")
+	}
+	return line, nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// promptPassword reads a line from the terminal without echoing it
+func promptPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Println()
+		}()
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	line, _ := stdin.ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+func stty(setting string) error {
+	cmd := exec.Command("stty", setting)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
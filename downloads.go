@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DownloadQueued = iota
+	DownloadRunning
+	DownloadDone
+	DownloadFailed
+)
+
+// DownloadJob is a song on the downloads page
+type DownloadJob struct {
+	Id     string
+	Title  string
+	Artist string
+	Suffix string
+	State  int
+	Done   int64
+	Total  int64 // -1 until known
+	Err    error
+}
+
+// downloadEntry is a downloaded song in the index
+type downloadEntry struct {
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+	Title    string    `json:"title"`
+	Artist   string    `json:"artist"`
+}
+
+// Downloads keeps songs on disk for playing offline. Songs are fetched one at
+// a time in the background. Once the cache grows past maxSize, the songs
+// played longest ago are deleted.
+type Downloads struct {
+	dir        string
+	maxSize    int64 // 0 for no limit
+	connection *SubsonicConnection
+
+	lock    sync.Mutex
+	entries map[string]*downloadEntry
+	dirty   bool
+	jobs    []*DownloadJob
+	// called, without lock held, whenever jobs or entries change
+	onChange func()
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+const downloadIndexFile = "index.json"
+
+// NewDownloads opens the cache in dir and starts downloading what is queued
+func NewDownloads(dir string, maxSize int64, connection *SubsonicConnection) *Downloads {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Downloads{
+		dir:        dir,
+		maxSize:    maxSize,
+		connection: connection,
+		entries:    make(map[string]*downloadEntry),
+		wake:       make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, downloadIndexFile)); err == nil {
+		if err := json.Unmarshal(data, &d.entries); err != nil {
+			connection.Logger.Printf("NewDownloads: reading the index -- %s", err.Error())
+		}
+	}
+	// files deleted by hand are gone
+	for id, entry := range d.entries {
+		if _, err := os.Stat(filepath.Join(dir, entry.File)); err != nil {
+			delete(d.entries, id)
+			d.dirty = true
+		}
+	}
+
+	go d.run()
+	return d
+}
+
+// Close stops downloading and saves the index
+func (d *Downloads) Close() {
+	d.cancel()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.saveIndex()
+}
+
+func (d *Downloads) SetOnChange(onChange func()) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.onChange = onChange
+}
+
+func (d *Downloads) changed() {
+	d.lock.Lock()
+	onChange := d.onChange
+	d.lock.Unlock()
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// Path returns the downloaded file of song id, or "" if it isn't downloaded
+func (d *Downloads) Path(id string) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	entry, ok := d.entries[id]
+	if !ok {
+		return ""
+	}
+	return filepath.Join(d.dir, entry.File)
+}
+
+// Touch counts song id as played now, which keeps it from being evicted
+// before songs played longer ago
+func (d *Downloads) Touch(id string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if entry, ok := d.entries[id]; ok {
+		entry.LastUsed = time.Now()
+		d.dirty = true
+	}
+}
+
+// Has reports whether song id is downloaded, without counting it as used
+func (d *Downloads) Has(id string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, ok := d.entries[id]
+	return ok
+}
+
+// Enqueue queues songs for download. Directories, songs already downloaded and
+// songs already queued are skipped.
+func (d *Downloads) Enqueue(songs ...SubsonicEntity) {
+	d.lock.Lock()
+	queued := make(map[string]bool)
+	for _, job := range d.jobs {
+		if job.State == DownloadQueued || job.State == DownloadRunning {
+			queued[job.Id] = true
+		}
+	}
+	added := false
+	for _, song := range songs {
+		if song.IsDirectory || queued[song.Id] {
+			continue
+		}
+		if _, ok := d.entries[song.Id]; ok {
+			continue
+		}
+		queued[song.Id] = true
+		d.jobs = append(d.jobs, &DownloadJob{
+			Id:     song.Id,
+			Title:  song.Title,
+			Artist: song.Artist,
+			Suffix: song.Suffix,
+			State:  DownloadQueued,
+			Total:  -1,
+		})
+		added = true
+	}
+	d.lock.Unlock()
+
+	if added {
+		d.Resume()
+		d.changed()
+	}
+}
+
+// Resume has the worker look for queued songs, for when there are new ones or
+// the server is back
+func (d *Downloads) Resume() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Jobs returns a copy of the songs queued, downloading, downloaded or failed
+// since stmp started, oldest first
+func (d *Downloads) Jobs() []DownloadJob {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	jobs := make([]DownloadJob, len(d.jobs))
+	for i, job := range d.jobs {
+		jobs[i] = *job
+	}
+	return jobs
+}
+
+// Usage returns how many bytes the downloaded songs take up, and the limit
+func (d *Downloads) Usage() (int64, int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.usage(), d.maxSize
+}
+
+// usage expects lock to be held
+func (d *Downloads) usage() int64 {
+	var used int64
+	for _, entry := range d.entries {
+		used += entry.Size
+	}
+	return used
+}
+
+// Remove deletes the downloaded file of song id, or drops it from the queue
+func (d *Downloads) Remove(id string) {
+	d.lock.Lock()
+	if entry, ok := d.entries[id]; ok {
+		os.Remove(filepath.Join(d.dir, entry.File))
+		delete(d.entries, id)
+		d.dirty = true
+		d.saveIndex()
+	}
+	for i, job := range d.jobs {
+		// a running download finishes, and is then cached as usual
+		if job.Id == id && job.State != DownloadRunning {
+			d.jobs = append(d.jobs[:i], d.jobs[i+1:]...)
+			break
+		}
+	}
+	d.lock.Unlock()
+	d.changed()
+}
+
+// run downloads queued songs until Close. Offline, songs stay queued until
+// the server is back.
+func (d *Downloads) run() {
+	for {
+		d.lock.Lock()
+		var job *DownloadJob
+		for _, candidate := range d.jobs {
+			if candidate.State == DownloadQueued && !d.connection.Offline() {
+				job = candidate
+				job.State = DownloadRunning
+				break
+			}
+		}
+		d.lock.Unlock()
+
+		if job == nil {
+			select {
+			case <-d.wake:
+				continue
+			case <-d.ctx.Done():
+				return
+			}
+		}
+
+		d.changed()
+		err := d.download(job)
+		if d.ctx.Err() != nil {
+			return
+		}
+
+		d.lock.Lock()
+		switch {
+		case err != nil && d.connection.Offline():
+			job.State = DownloadQueued
+			job.Done = 0
+		case err != nil:
+			job.State = DownloadFailed
+			job.Err = err
+		default:
+			job.State = DownloadDone
+		}
+		d.lock.Unlock()
+		if err != nil && !d.connection.Offline() {
+			d.connection.Logger.PrintError(err, "Downloads: %s", job.Title)
+		}
+		d.changed()
+	}
+}
+
+// download fetches job into the cache, evicting old songs to make room
+func (d *Downloads) download(job *DownloadJob) error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+
+	// ids come from the server and may not make good file names
+	name := fmt.Sprintf("%x", md5.Sum([]byte(job.Id)))
+	if job.Suffix != "" {
+		name += "." + job.Suffix
+	}
+	part, err := ioutil.TempFile(d.dir, name+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(part.Name())
+
+	// only redraw when the percentage changes
+	lastPercent := int64(-1)
+	err = d.connection.Download(d.ctx, job.Id, part, func(done, total int64) {
+		d.lock.Lock()
+		job.Done, job.Total = done, total
+		d.lock.Unlock()
+		if total > 0 && done*100/total != lastPercent {
+			lastPercent = done * 100 / total
+			d.changed()
+		}
+	})
+	if closeErr := part.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(part.Name(), filepath.Join(d.dir, name)); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.entries[job.Id] = &downloadEntry{
+		File:     name,
+		Size:     job.Done,
+		LastUsed: time.Now(),
+		Title:    job.Title,
+		Artist:   job.Artist,
+	}
+	d.evict(job.Id)
+	d.dirty = true
+	d.saveIndex()
+	return nil
+}
+
+// evict deletes the songs used longest ago until the cache fits in maxSize,
+// keeping at least keep. It expects lock to be held.
+func (d *Downloads) evict(keep string) {
+	if d.maxSize <= 0 {
+		return
+	}
+	used := d.usage()
+	if used <= d.maxSize {
+		return
+	}
+
+	ids := make([]string, 0, len(d.entries))
+	for id := range d.entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return d.entries[ids[i]].LastUsed.Before(d.entries[ids[j]].LastUsed)
+	})
+
+	for _, id := range ids {
+		if used <= d.maxSize {
+			break
+		}
+		if id == keep {
+			continue
+		}
+		entry := d.entries[id]
+		if err := os.Remove(filepath.Join(d.dir, entry.File)); err != nil && !os.IsNotExist(err) {
+			d.connection.Logger.Printf("Downloads: evicting %s -- %s", entry.Title, err.Error())
+			continue
+		}
+		used -= entry.Size
+		delete(d.entries, id)
+		d.dirty = true
+	}
+}
+
+// saveIndex writes the index if it changed. It expects lock to be held.
+func (d *Downloads) saveIndex() {
+	if !d.dirty {
+		return
+	}
+	data, err := json.Marshal(d.entries)
+	if err == nil {
+		err = os.MkdirAll(d.dir, 0755)
+	}
+	if err == nil {
+		// write and rename, so a crash can't leave half an index
+		path := filepath.Join(d.dir, downloadIndexFile)
+		err = ioutil.WriteFile(path+".tmp", data, 0644)
+		if err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		d.connection.Logger.Printf("Downloads: saving the index -- %s", err.Error())
+		return
+	}
+	d.dirty = false
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvict(t *testing.T) {
+	// a downloaded song, used minutes ago
+	type cached struct {
+		id      string
+		size    int64
+		minutes int
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		cached  []cached
+		keep    string
+		left    []string
+	}{
+		{
+			name:    "fits",
+			maxSize: 30,
+			cached:  []cached{{"a", 10, 3}, {"b", 10, 2}, {"c", 10, 1}},
+			keep:    "c",
+			left:    []string{"a", "b", "c"},
+		},
+		{
+			name:   "no limit",
+			cached: []cached{{"a", 10, 3}, {"b", 10, 2}, {"c", 10, 1}},
+			keep:   "c",
+			left:   []string{"a", "b", "c"},
+		},
+		{
+			name:    "the one used longest ago goes",
+			maxSize: 25,
+			cached:  []cached{{"a", 10, 2}, {"b", 10, 3}, {"c", 10, 1}},
+			keep:    "c",
+			left:    []string{"a", "c"},
+		},
+		{
+			name:    "as many as it takes",
+			maxSize: 15,
+			cached:  []cached{{"a", 10, 4}, {"b", 5, 3}, {"c", 5, 2}, {"d", 10, 1}},
+			keep:    "d",
+			left:    []string{"c", "d"},
+		},
+		{
+			name:    "a song played since stays",
+			maxSize: 20,
+			cached:  []cached{{"a", 10, 0}, {"b", 10, 3}, {"c", 10, 1}},
+			keep:    "c",
+			left:    []string{"a", "c"},
+		},
+		{
+			name:    "the new song stays even when it is too big",
+			maxSize: 15,
+			cached:  []cached{{"a", 10, 2}, {"b", 10, 1}, {"c", 20, 3}},
+			keep:    "c",
+			left:    []string{"c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &Downloads{
+				dir:        t.TempDir(),
+				maxSize:    test.maxSize,
+				connection: &SubsonicConnection{Logger: testLogger()},
+				entries:    make(map[string]*downloadEntry),
+			}
+			now := time.Now()
+			for _, song := range test.cached {
+				if err := ioutil.WriteFile(filepath.Join(d.dir, song.id), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				d.entries[song.id] = &downloadEntry{
+					File:     song.id,
+					Size:     song.size,
+					LastUsed: now.Add(-time.Duration(song.minutes) * time.Minute),
+				}
+			}
+
+			d.evict(test.keep)
+
+			var left []string
+			for id := range d.entries {
+				left = append(left, id)
+				if _, err := os.Stat(filepath.Join(d.dir, id)); err != nil {
+					t.Errorf("%s is still in the index, but not on disk", id)
+				}
+			}
+			sort.Strings(left)
+			if !reflect.DeepEqual(left, test.left) {
+				t.Errorf("left %v, want %v", left, test.left)
+			}
+			for _, song := range test.cached {
+				if _, ok := d.entries[song.id]; ok {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(d.dir, song.id)); !os.IsNotExist(err) {
+					t.Errorf("%s was evicted, but is still on disk", song.id)
+				}
+			}
+		})
+	}
+}
+
+// TestDownloadsWaitWhileOffline queues a song while the server is out of
+// reach; it is fetched once the connection is back
+func TestDownloadsWaitWhileOffline(t *testing.T) {
+	var requests int32
+	connection := newTestConnection(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("song"))
+	})
+	connection.offline = true
+
+	d := NewDownloads(t.TempDir(), 0, connection)
+	defer d.Close()
+	changes := make(chan struct{}, 100)
+	d.SetOnChange(func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	})
+
+	d.Enqueue(SubsonicEntity{Id: "1", Title: "one", Suffix: "mp3"})
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("%d requests while offline", n)
+	}
+	if jobs := d.Jobs(); len(jobs) != 1 || jobs[0].State != DownloadQueued {
+		t.Fatalf("jobs while offline %+v, want one queued", jobs)
+	}
+
+	connection.lock.Lock()
+	connection.offline = false
+	connection.lock.Unlock()
+	d.Resume()
+
+	timeout := time.After(5 * time.Second)
+	for !d.Has("1") {
+		select {
+		case <-changes:
+		case <-timeout:
+			t.Fatalf("not downloaded once back online, jobs %+v", d.Jobs())
+		}
+	}
+	data, err := ioutil.ReadFile(d.Path("1"))
+	if err != nil || string(data) != "song" {
+		t.Errorf("downloaded %q, %v", data, err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+func (ui *Ui) createDownloadsPage(titleFlex *tview.Flex) *tview.Flex {
+	ui.downloadsList = tview.NewList().ShowSecondaryText(false)
+	ui.downloadsList.SetBorder(true)
+
+	ui.downloadsList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyDelete || keyName(event) == keybind("removeDownload") {
+			ui.handleRemoveDownload()
+			return nil
+		}
+		return event
+	})
+
+	ui.followDownloads()
+
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(titleFlex, 1, 0, false).
+		AddItem(ui.downloadsList, 0, 1, true)
+}
+
+// followDownloads keeps the downloads page up to date with the current
+// connection's downloads, and has the player play them
+func (ui *Ui) followDownloads() {
+	ui.player.SetResolver(ui.connection.LocalFile)
+	if downloads := ui.connection.Downloads; downloads != nil {
+		downloads.SetOnChange(func() {
+			ui.app.QueueUpdateDraw(func() {
+				// the connection may have changed since
+				if ui.connection.Downloads == downloads {
+					ui.updateDownloadsList()
+				}
+			})
+		})
+	}
+	ui.updateDownloadsList()
+}
+
+func (ui *Ui) updateDownloadsList() {
+	downloads := ui.connection.Downloads
+	current := ui.downloadsList.GetCurrentItem()
+	ui.downloadsList.Clear()
+	ui.downloadIds = nil
+
+	if downloads == nil {
+		ui.downloadsList.SetTitle("Downloads")
+		ui.downloadsList.AddItem("[::d]downloads need a cache directory", "", 0, nil)
+		return
+	}
+
+	used, max := downloads.Usage()
+	title := "Downloads " + formatBytes(used)
+	if max > 0 {
+		title += " / " + formatBytes(max)
+	}
+	ui.downloadsList.SetTitle(title)
+
+	for _, job := range downloads.Jobs() {
+		ui.downloadIds = append(ui.downloadIds, job.Id)
+		ui.downloadsList.AddItem(downloadListTextFormat(job), "", 0, nil)
+	}
+	if current < len(ui.downloadIds) {
+		ui.downloadsList.SetCurrentItem(current)
+	}
+}
+
+func downloadListTextFormat(job DownloadJob) string {
+	var status string
+	switch job.State {
+	case DownloadQueued:
+		status = "[::d]queued"
+	case DownloadRunning:
+		if job.Total > 0 {
+			status = fmt.Sprintf("[yellow]%3d%%", job.Done*100/job.Total)
+		} else {
+			status = "[yellow]" + formatBytes(job.Done)
+		}
+	case DownloadDone:
+		status = "[green]done"
+	case DownloadFailed:
+		status = "[red]failed: " + tview.Escape(job.Err.Error())
+	}
+	return fmt.Sprintf("%s - %s  %s", tview.Escape(job.Title), tview.Escape(job.Artist), status)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%d kB", n>>10)
+}
+
+func (ui *Ui) handleRemoveDownload() {
+	index := ui.downloadsList.GetCurrentItem()
+	if ui.connection.Downloads == nil || index < 0 || index >= len(ui.downloadIds) {
+		return
+	}
+	ui.connection.Downloads.Remove(ui.downloadIds[index])
+}
+
+// handleDownloadEntity downloads the selected song, or every song in the
+// selected album or directory
+func (ui *Ui) handleDownloadEntity() {
+	downloads := ui.connection.Downloads
+	if downloads == nil || ui.currentDirectory == nil {
+		return
+	}
+
+	currentIndex := ui.entityList.GetCurrentItem()
+	// account for the [..] entry
+	if ui.currentDirectory.Parent != "" {
+		currentIndex--
+	}
+	if currentIndex < 0 || len(ui.currentDirectory.Entities) <= currentIndex {
+		return
+	}
+	entity := ui.currentDirectory.Entities[currentIndex]
+
+	if !entity.IsDirectory {
+		downloads.Enqueue(entity)
+		return
+	}
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		songs, err := fetchDirectorySongs(ctx, connection, entity.Id)
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, "handleDownloadEntity: %s", entity.Title)
+				return
+			}
+			downloads.Enqueue(songs...)
+		}
+	})
+}
+
+// handleDownloadPlaylist downloads every song in the selected playlist
+func (ui *Ui) handleDownloadPlaylist() {
+	index := ui.playlistList.GetCurrentItem()
+	if ui.connection.Downloads == nil || index < 0 || index >= len(ui.playlists) {
+		return
+	}
+	ui.connection.Downloads.Enqueue(ui.playlists[index].Entries...)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"sync"
+)
+
+type PlayerEventType int
+
+const (
+	EventTrackStarted PlayerEventType = iota
+	EventTrackEnded
+	EventPaused
+	EventResumed
+	EventSeeked
+	EventVolumeChanged
+	EventQueueChanged
+	// sent as playback progresses, for progress bars and synced lyrics
+	EventPositionChanged
+)
+
+func (t PlayerEventType) String() string {
+	switch t {
+	case EventTrackStarted:
+		return "TrackStarted"
+	case EventTrackEnded:
+		return "TrackEnded"
+	case EventPaused:
+		return "Paused"
+	case EventResumed:
+		return "Resumed"
+	case EventSeeked:
+		return "Seeked"
+	case EventVolumeChanged:
+		return "VolumeChanged"
+	case EventQueueChanged:
+		return "QueueChanged"
+	case EventPositionChanged:
+		return "PositionChanged"
+	}
+	return "Unknown"
+}
+
+// PlayerEvent is what subscribers get from Player.Subscribe. Only the fields
+// that make sense for Type are set.
+type PlayerEvent struct {
+	Type PlayerEventType
+	// TrackStarted and TrackEnded
+	Track *QueueItem
+	// TrackEnded: the track played to the end rather than being stopped or
+	// replaced
+	Finished bool
+	// TrackEnded: the track couldn't be played
+	Failed bool
+	// Seeked and PositionChanged, in seconds
+	Position float64
+	Duration float64
+	// VolumeChanged, in percent
+	Volume int64
+}
+
+// how many events a backend may get ahead of the player
+const eventBuffer = 64
+
+// eventBus fans player events out to any number of subscribers
+type eventBus struct {
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      bool
+}
+
+// subscriber queues events for one subscription, so the player never waits
+// for a slow subscriber and a slow subscriber never misses a track starting
+// or ending. Only the latest of a run of position or volume changes is kept.
+type subscriber struct {
+	events chan PlayerEvent
+	// closed when the subscriber stops listening
+	stop chan struct{}
+
+	lock    sync.Mutex
+	wake    *sync.Cond
+	pending []PlayerEvent
+	closed  bool
+}
+
+func newSubscriber() *subscriber {
+	s := &subscriber{
+		events: make(chan PlayerEvent),
+		stop:   make(chan struct{}),
+	}
+	s.wake = sync.NewCond(&s.lock)
+	go s.run()
+	return s
+}
+
+// coalesces reports whether an event of type t may replace the one of the
+// same type before it, for events that only say what the state is now
+func coalesces(t PlayerEventType) bool {
+	return t == EventPositionChanged || t == EventVolumeChanged
+}
+
+func (s *subscriber) push(event PlayerEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n := len(s.pending); n > 0 && coalesces(event.Type) && s.pending[n-1].Type == event.Type {
+		s.pending[n-1] = event
+	} else {
+		s.pending = append(s.pending, event)
+	}
+	s.wake.Signal()
+}
+
+// close has the subscriber deliver what is pending, then close its channel
+func (s *subscriber) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	s.wake.Signal()
+}
+
+// run delivers pending events in order until the subscription ends
+func (s *subscriber) run() {
+	defer close(s.events)
+	for {
+		s.lock.Lock()
+		for len(s.pending) == 0 && !s.closed {
+			s.wake.Wait()
+		}
+		if len(s.pending) == 0 {
+			s.lock.Unlock()
+			return
+		}
+		event := s.pending[0]
+		s.pending[0] = PlayerEvent{}
+		s.pending = s.pending[1:]
+		s.lock.Unlock()
+
+		select {
+		case s.events <- event:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every player event from now on. The
+// player never waits for subscribers; events queue up for a subscriber that
+// falls behind, except that it only gets the latest position and volume. The
+// channel is closed when the player shuts down, once the events before that
+// are delivered, or right away by calling the returned function.
+func (p *Player) Subscribe() (<-chan PlayerEvent, func()) {
+	bus := &p.events
+	s := newSubscriber()
+
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	if bus.closed {
+		s.close()
+		return s.events, func() {}
+	}
+	if bus.subscribers == nil {
+		bus.subscribers = make(map[*subscriber]struct{})
+	}
+	bus.subscribers[s] = struct{}{}
+
+	return s.events, func() {
+		bus.lock.Lock()
+		defer bus.lock.Unlock()
+		if _, present := bus.subscribers[s]; present {
+			delete(bus.subscribers, s)
+			close(s.stop)
+			s.close()
+		}
+	}
+}
+
+func (p *Player) publish(event PlayerEvent) {
+	bus := &p.events
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	for s := range bus.subscribers {
+		s.push(event)
+	}
+}
+
+// closeSubscribers ends every subscription, subscribers see their channel
+// close once they have had every event
+func (p *Player) closeSubscribers() {
+	bus := &p.events
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	for s := range bus.subscribers {
+		s.close()
+	}
+	bus.subscribers = nil
+	bus.closed = true
+}
+
+// dispatchEvents turns backend events into player events until the backend
+// shuts down
+func (p *Player) dispatchEvents() {
+	var duration float64
+	var volume int64 = -1
+	paused := false
+	seeked := false
+
+	for e := range p.backend.Events() {
+		switch e.Type {
+		case BackendFileStarted:
+			track := p.fileStarted()
+			p.publish(PlayerEvent{Type: EventTrackStarted, Track: track})
+
+		case BackendFileEnded:
+			ended, advanced, next := p.fileEnded()
+			if ended != nil {
+				p.publish(PlayerEvent{Type: EventTrackEnded, Track: ended, Finished: e.EOF, Failed: e.Failed})
+			}
+			if advanced {
+				p.publish(PlayerEvent{Type: EventQueueChanged})
+			}
+			if next != nil {
+				// the backend may not answer until more of its events are
+				// read, so this loop mustn't wait for it
+				go func() {
+					if err := p.load(*next); err != nil {
+						p.logger.Printf("dispatchEvents: PlayNextTrack -- %s", err.Error())
+					}
+				}()
+			}
+
+		case BackendSeeked:
+			// the position comes next; asking the backend for it here would
+			// wait on the events this loop reads
+			seeked = true
+
+		case BackendPositionChanged:
+			if seeked {
+				seeked = false
+				p.publish(PlayerEvent{Type: EventSeeked, Position: e.Position, Duration: duration})
+				continue
+			}
+			p.publish(PlayerEvent{Type: EventPositionChanged, Position: e.Position, Duration: duration})
+
+		case BackendDurationChanged:
+			duration = e.Duration
+
+		case BackendVolumeChanged:
+			if e.Volume != volume {
+				volume = e.Volume
+				p.publish(PlayerEvent{Type: EventVolumeChanged, Volume: volume})
+			}
+
+		case BackendPauseChanged:
+			if e.Paused != paused {
+				paused = e.Paused
+				if paused {
+					p.publish(PlayerEvent{Type: EventPaused})
+				} else {
+					p.publish(PlayerEvent{Type: EventResumed})
+				}
+			}
+		}
+	}
+
+	p.closeSubscribers()
+}
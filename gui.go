@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
+	"reflect"
 	"sort"
 	"strings"
 	"time"
@@ -10,7 +13,6 @@ import (
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	"github.com/spf13/viper"
-	"github.com/wildeyedskies/go-mpv/mpv"
 	//"github.com/mpv-player/mpv"
 )
 
@@ -32,40 +34,126 @@ type Ui struct {
 	currentDirectory  *SubsonicDirectory
 	artistList        *tview.List
 	artistIdList      []string
+	artistNameList    []string
+	browserColumns    *tview.Flex
+	artistInfoFlex    *tview.Flex
+	artistBio         *tview.TextView
+	similarArtistList *tview.List
+	similarArtists    []SubsonicArtist
+	artistInfoCache   map[string]SubsonicArtistInfo
+	artistInfoId      string
+	showArtistInfo    bool
 	starIdList        map[string]struct{}
+	// folders starred by folder id, see entityStar
+	starredFolders    map[string]struct{}
+	ratings           map[string]int
+	ratingTarget      string
+	rateReturnFocus   tview.Primitive
+	rateList          *tview.List
+	coverArt          *CoverArtView
+	coverArtId        string
+	nowPlayingInfo    *tview.TextView
+	titleFlex         *tview.Flex
+	toast             *tview.TextView
+	toastCount        int
+	nowPlayingProgress *tview.TextView
+	lyricsView        *tview.TextView
+	lyrics            *Lyrics
+	lyricsLine        int
+	starred           SubsonicStarred
+	starredArtistList *tview.List
+	starredAlbumList  *tview.List
+	starredSongList   *tview.List
 	playlists         []SubsonicPlaylist
 	connection        *SubsonicConnection
 	player            *Player
-	scrobbleTimer     *time.Timer
+	// the server profile connection belongs to, "" without profiles
+	profile           string
+	profileList       *tview.List
+	scrobblerCancel   context.CancelFunc
+	// services plays go to besides the server
+	scrobblers        []*ScrobbleTarget
+	history           *History
+	historyList       *tview.List
+	statsView         *tview.TextView
+	statsPeriod       int
+	playlistForm      *tview.Form
+	playlistFormReturnFocus tview.Primitive
+	// edits waiting to be sent, by playlist id, see editPlaylist
+	playlistEdits     map[string][]playlistEdit
+	// what the add to playlist list adds, and where it was opened from
+	addToPlaylistSelected    func(playlist *SubsonicPlaylist)
+	addToPlaylistReturnFocus tview.Primitive
+	// whether the user was told server.proxyBasicAuth may be failing
+	proxyBasicAuthWarned bool
+	downloadsList     *tview.List
+	downloadIds       []string
+	// cancelled on quit, so requests in flight give up with the app
+	ctx               context.Context
+	cancel            context.CancelFunc
+	coverArtCancel    context.CancelFunc
+	browseCancel      context.CancelFunc
+	spinner           *tview.TextView
+	spinnerStop       chan struct{}
+	offlineStatus     *tview.TextView
+	scrobbleStatus    *tview.TextView
+	loading           int
 	currentPlaylistIndex int
 }
 
+// handleEntitySelected loads a directory into the entity list. Moving through
+// the artist list quickly cancels the loads that are no longer wanted.
 func (ui *Ui) handleEntitySelected(directoryId string) {
-	response, err := ui.connection.GetMusicDirectory(directoryId)
-	sort.Sort(response.Directory.Entities)
-	if err != nil {
-		ui.connection.Logger.Printf("handleEntitySelected: GetMusicDirectory %s -- %s", directoryId, err.Error())
+	if ui.browseCancel != nil {
+		ui.browseCancel()
 	}
+	ctx, cancel := context.WithCancel(ui.ctx)
+	ui.browseCancel = cancel
 
-	ui.currentDirectory = &response.Directory
+	// an empty directory until the real one arrives, so keys pressed on the
+	// loading row don't act on the previous directory
+	ui.currentDirectory = &SubsonicDirectory{Id: directoryId}
 	ui.entityList.Clear()
-	if response.Directory.Parent != "" {
+	ui.entityList.AddItem("[::d]loading…", "", 0, nil)
+
+	connection := ui.connection
+	ui.runInBackground(ctx, func(ctx context.Context) func() {
+		response, err := connection.GetMusicDirectory(ctx, directoryId)
+		return func() {
+			if err != nil {
+				ui.entityList.Clear()
+				connection.Logger.PrintError(err, "handleEntitySelected: GetMusicDirectory %s", directoryId)
+				return
+			}
+			ui.showDirectory(&response.Directory)
+		}
+	})
+}
+
+func (ui *Ui) showDirectory(directory *SubsonicDirectory) {
+	// the response may be the library's, which is shared, sort a copy
+	sorted := *directory
+	sorted.Entities = append(SubsonicEntities(nil), directory.Entities...)
+	sort.Sort(sorted.Entities)
+	directory = &sorted
+
+	ui.currentDirectory = directory
+	ui.noteRatings(directory.Entities)
+	ui.entityList.Clear()
+	if directory.Parent != "" {
 		ui.entityList.AddItem(tview.Escape("[..]"), "", 0,
-			ui.makeEntityHandler(response.Directory.Parent))
+			ui.makeEntityHandler(directory.Parent))
 	}
 
-	for _, entity := range response.Directory.Entities {
+	for _, entity := range directory.Entities {
 		var title string
-		var id = entity.Id
 		var handler func()
+		title = ui.entityTextFormat(entity)
 		if entity.IsDirectory {
-			title = tview.Escape("[" + entity.Title + "]")
 			handler = ui.makeEntityHandler(entity.Id)
 		} else {
-			title = entityListTextFormat(entity, ui.starIdList )
-			handler = makeSongHandler(id, ui.connection.GetPlayUrl(&entity),
-				title, stringOr(entity.Artist, response.Directory.Name),
-				entity.Duration, ui.player, ui.queueList, ui.starIdList)
+			handler = makeSongHandler(ui.makeQueueItem(&entity, directory.Name),
+				ui.player, ui.queueList, ui.starIdList, ui.ratings)
 		}
 
 		ui.entityList.AddItem(title, "", 0, handler)
@@ -74,15 +162,14 @@ func (ui *Ui) handleEntitySelected(directoryId string) {
 
 func (ui *Ui) handlePlaylistSelected(playlist SubsonicPlaylist) {
 	ui.selectedPlaylist.Clear()
+	ui.noteRatings(playlist.Entries)
 
 	for _, entity := range playlist.Entries {
 		var title string
 		var handler func()
 
-		var id = entity.Id
-
-		title = entity.getSongTitle()
-		handler = makeSongHandler(id, ui.connection.GetPlayUrl(&entity), title, entity.Artist, entity.Duration, ui.player, ui.queueList, ui.starIdList)
+		title = ui.offlineTextFormat(entity.getSongTitle(), entity.Id, false)
+		handler = makeSongHandler(ui.makeQueueItem(&entity, ""), ui.player, ui.queueList, ui.starIdList, ui.ratings)
 
 		ui.selectedPlaylist.AddItem(title, "", 0, handler)
 	}
@@ -97,68 +184,65 @@ func (ui *Ui) handlePlaylistSelected(playlist SubsonicPlaylist) {
 
 func (ui *Ui) handleDeleteFromQueue() {
 	currentIndex := ui.queueList.GetCurrentItem()
-	queue := ui.player.Queue
 
-	if currentIndex == -1 || len(ui.player.Queue) < currentIndex {
+	if currentIndex == -1 || ui.player.QueueLen() <= currentIndex {
 		return
 	}
 
-	// if the deleted item was the first one, and the player is loaded
-	// remove the track. Removing the track auto starts the next one
-	if currentIndex == 0 {
-		if isSongLoaded, err := ui.player.IsSongLoaded(); err != nil {
-			ui.connection.Logger.Printf("handleDeleteFromQueue: IsSongLoaded -- %s", err.Error())
-			return
-		} else if isSongLoaded {
-			ui.player.Stop()
-		}
-		return
-	}
-
-	// remove the item from the queue
-	if len(ui.player.Queue) > 1 {
-		ui.player.Queue = append(queue[:currentIndex], queue[currentIndex+1:]...)
-	} else {
-		ui.player.Queue = make([]QueueItem, 0)
+	// removing the track being played moves on to the next one
+	if err := ui.player.RemoveFromQueue(currentIndex); err != nil {
+		ui.connection.Logger.Printf("handleDeleteFromQueue: RemoveFromQueue -- %s", err.Error())
 	}
 
-	updateQueueList(ui.player, ui.queueList, ui.starIdList)
+	updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
 }
 
 func (ui *Ui) handleAddRandomSongs() {
-	ui.addRandomSongsToQueue()
-	updateQueueList(ui.player, ui.queueList, ui.starIdList)
+	ui.enqueueInBackground("handleAddRandomSongs: GetRandomSongs", false, fetchRandomSongs)
 }
 
 func (ui *Ui) handleToggleStar() {
 	currentIndex := ui.queueList.GetCurrentItem()
-	queue := ui.player.Queue
-
-	if currentIndex == -1 || len(ui.player.Queue) < currentIndex {
+	entity := ui.player.QueueItemAt(currentIndex)
+	if entity == nil {
 		return
 	}
 
-	var entity = queue[currentIndex]
-
-	// If the song is already in the star list, remove it
-	_, remove := ui.starIdList[entity.Id]
-
-	// resp, _ := ui.connection.ToggleStar(entity.Id, remove)
-	ui.connection.ToggleStar(entity.Id, ui.starIdList)
-
-	if (remove) {
-		delete(ui.starIdList, entity.Id)
-	} else {
-		ui.starIdList[entity.Id] = struct{}{}
-	}
+	ui.toggleStarInBackground("handleToggleStar", entity.Id, ui.starIdList, (*SubsonicConnection).ToggleStar, func() {
+		updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
+		ui.redrawLists()
+	})
+}
 
-	var text = queueListTextFormat(ui.player.Queue[currentIndex], ui.starIdList )
-	updateQueueListItem(ui.queueList, currentIndex, text)
-	// Update the entity list to reflect any changes
-	ui.connection.Logger.Printf("entity test", ui.currentDirectory)
-	if (ui.currentDirectory != nil) {
-		ui.handleEntitySelected(ui.currentDirectory.Id) 
+// toggleStarInBackground stars id with toggle, or unstars it if it is in
+// stars, off the UI goroutine. Once the server has taken it stars is updated
+// and done called, on the UI goroutine.
+func (ui *Ui) toggleStarInBackground(caller string, id string, stars map[string]struct{}, toggle func(connection *SubsonicConnection, ctx context.Context, id string, starredItems map[string]struct{}) (*SubsonicResponse, error), done func()) {
+	_, remove := stars[id]
+	// toggle only looks id up, in a map of its own as stars stays with the UI
+	starred := map[string]struct{}{}
+	if remove {
+		starred[id] = struct{}{}
 	}
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		_, err := toggle(connection, ctx, id, starred)
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, "%s: ToggleStar %s", caller, id)
+				return
+			}
+			if ui.connection != connection {
+				return
+			}
+			if remove {
+				delete(stars, id)
+			} else {
+				stars[id] = struct{}{}
+			}
+			done()
+		}
+	})
 }
 
 func (ui *Ui) handleAddEntityToQueue() {
@@ -180,42 +264,222 @@ func (ui *Ui) handleAddEntityToQueue() {
 	entity := ui.currentDirectory.Entities[currentIndex]
 
 	if entity.IsDirectory {
-		ui.addDirectoryToQueue(&entity)
-	} else {
-		ui.addSongToQueue(&entity)
+		ui.enqueueInBackground("handleAddEntityToQueue: "+entity.Title, false, func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error) {
+			return fetchDirectorySongs(ctx, connection, entity.Id)
+		})
+		return
 	}
 
-	updateQueueList(ui.player, ui.queueList, ui.starIdList)
+	ui.addSongToQueue(&entity)
+	updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
 }
 
 func (ui *Ui) handleToggleEntityStar() {
 	currentIndex := ui.entityList.GetCurrentItem()
+	entityIndex := currentIndex
 
-	var entity = ui.currentDirectory.Entities[currentIndex-1]
+	// account for the [..] row, same as handleAddEntityToQueue
+	if ui.currentDirectory.Parent != "" {
+		entityIndex--
+	}
 
-	// If the song is already in the star list, remove it
-	_, remove := ui.starIdList[entity.Id]
+	if entityIndex < 0 || len(ui.currentDirectory.Entities) <= entityIndex {
+		return
+	}
 
-	ui.connection.ToggleStar(entity.Id, ui.starIdList)
+	var entity = ui.currentDirectory.Entities[entityIndex]
 
-	if (remove) {
-		delete(ui.starIdList, entity.Id)
-	} else {
-		ui.starIdList[entity.Id] = struct{}{}
+	id, album, stars := ui.entityStar(entity)
+	toggle := (*SubsonicConnection).ToggleStar
+	if album {
+		toggle = (*SubsonicConnection).ToggleAlbumStar
+	}
+	ui.toggleStarInBackground("handleToggleEntityStar", id, stars, toggle, func() {
+		if album {
+			// the starred page lists albums from getStarred2
+			ui.refreshStarred()
+		}
+		ui.redrawLists()
+		updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
+	})
+}
+
+func (ui *Ui) handleToggleArtistStar() {
+	currentIndex := ui.artistList.GetCurrentItem()
+	if currentIndex == -1 || len(ui.artistIdList) <= currentIndex {
+		return
 	}
 
-	var text = entityListTextFormat(entity, ui.starIdList )
-	updateEntityListItem(ui.entityList, currentIndex, text)
-	updateQueueList(ui.player, ui.queueList, ui.starIdList)
+	// getIndexes only gives folder ids, so the artist is starred as a folder
+	id := ui.artistIdList[currentIndex]
+	ui.toggleStarInBackground("handleToggleArtistStar", id, ui.starredFolders, (*SubsonicConnection).ToggleStar, ui.redrawLists)
 }
 
-func entityListTextFormat(queueItem SubsonicEntity, starredItems map[string]struct{} ) string {
+func entityListTextFormat(queueItem SubsonicEntity, hasStar bool, ratings map[string]int) string {
 	var star = ""
-	_, hasStar := starredItems[queueItem.Id]
 	if hasStar {
 		star = " [red]♥"
 	}
-	return queueItem.Title + star
+	if queueItem.IsDirectory {
+		return tview.Escape("["+queueItem.Title+"]") + star
+	}
+	return queueItem.Title + star + ratingTextFormat(ratings[queueItem.Id])
+}
+
+// noteRatings records the server side rating of entities we haven't seen yet.
+// Ratings changed in this session win over whatever is in cached responses.
+func (ui *Ui) noteRatings(entities SubsonicEntities) {
+	for _, entity := range entities {
+		if _, present := ui.ratings[entity.Id]; !present {
+			ui.ratings[entity.Id] = entity.UserRating
+		}
+	}
+}
+
+// selectedSongId returns the id of the song highlighted in the focused list,
+// or an empty string if nothing rateable is focused
+func (ui *Ui) selectedSongId() string {
+	switch ui.app.GetFocus() {
+	case ui.entityList:
+		if ui.currentDirectory == nil {
+			return ""
+		}
+		index := ui.entityList.GetCurrentItem()
+		if ui.currentDirectory.Parent != "" {
+			index--
+		}
+		if index < 0 || len(ui.currentDirectory.Entities) <= index || ui.currentDirectory.Entities[index].IsDirectory {
+			return ""
+		}
+		return ui.currentDirectory.Entities[index].Id
+	case ui.queueList:
+		if item := ui.player.QueueItemAt(ui.queueList.GetCurrentItem()); item != nil {
+			return item.Id
+		}
+		return ""
+	case ui.selectedPlaylist:
+		playlistIndex := ui.playlistList.GetCurrentItem()
+		index := ui.selectedPlaylist.GetCurrentItem()
+		if playlistIndex < 0 || len(ui.playlists) <= playlistIndex ||
+			index < 0 || len(ui.playlists[playlistIndex].Entries) <= index {
+			return ""
+		}
+		return ui.playlists[playlistIndex].Entries[index].Id
+	case ui.starredSongList:
+		index := ui.starredSongList.GetCurrentItem()
+		if index < 0 || len(ui.starred.Songs) <= index {
+			return ""
+		}
+		return ui.starred.Songs[index].Id
+	}
+	return ""
+}
+
+// showRatePage opens the rating dialog for the song with the given id
+func (ui *Ui) showRatePage(id string) {
+	if id == "" {
+		return
+	}
+	ui.ratingTarget = id
+	// remember where the dialog was opened from so focus can go back there
+	ui.rateReturnFocus = ui.app.GetFocus()
+	ui.rateList.SetCurrentItem(5 - ui.ratings[id])
+	ui.pages.ShowPage("rate")
+	ui.app.SetFocus(ui.rateList)
+}
+
+func (ui *Ui) handleSetRating(id string, rating int) {
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		_, err := connection.SetRating(ctx, id, rating)
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, "handleSetRating: SetRating %s", id)
+				return
+			}
+			if ui.connection != connection {
+				return
+			}
+			ui.ratings[id] = rating
+			ui.redrawLists()
+			updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
+		}
+	})
+}
+
+func (ui *Ui) createRatePage() tview.Primitive {
+	for rating := 5; rating > 0; rating-- {
+		ui.rateList.AddItem(strings.Repeat("★", rating)+strings.Repeat("☆", 5-rating), "", 0, nil)
+	}
+	ui.rateList.AddItem("Clear rating", "", 0, nil)
+
+	ui.rateList.SetBorder(true).
+		SetTitle("Rate song")
+
+	ui.rateList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEnter {
+			ui.handleSetRating(ui.ratingTarget, 5-ui.rateList.GetCurrentItem())
+		} else if event.Key() != tcell.KeyEscape {
+			return event
+		}
+		ui.pages.HidePage("rate")
+		if ui.rateReturnFocus != nil {
+			ui.app.SetFocus(ui.rateReturnFocus)
+		}
+		return nil
+	})
+
+	return makeModal(ui.rateList, 20, 8)
+}
+
+func artistListTextFormat(name string, id string, starredItems map[string]struct{}) string {
+	if _, hasStar := starredItems[id]; hasStar {
+		return name + " [red]♥"
+	}
+	return name
+}
+
+// artistTextFormat is artistListTextFormat for row i of the artist list
+func (ui *Ui) artistTextFormat(i int) string {
+	id := ui.artistIdList[i]
+	return ui.offlineTextFormat(artistListTextFormat(ui.artistNameList[i], id, ui.starredFolders), id, true)
+}
+
+// entityTextFormat is entityListTextFormat with the UI's stars and ratings
+func (ui *Ui) entityTextFormat(entity SubsonicEntity) string {
+	id, _, stars := ui.entityStar(entity)
+	_, hasStar := stars[id]
+	return ui.offlineTextFormat(entityListTextFormat(entity, hasStar, ui.ratings), entity.Id, entity.IsDirectory)
+}
+
+// entityStar returns the id a browser entry is starred by, whether that is an
+// ID3 album id, and the ids its heart is looked up in. getStarred2, and so
+// the starred page, goes by ID3 ids, which a folder only has when the server
+// gives it an album id; other folders are starred by folder id, and kept
+// apart, since on some servers the two kinds of id overlap.
+func (ui *Ui) entityStar(entity SubsonicEntity) (id string, album bool, stars map[string]struct{}) {
+	switch {
+	case !entity.IsDirectory:
+		return entity.Id, false, ui.starIdList
+	case entity.AlbumId != "":
+		return entity.AlbumId, true, ui.starIdList
+	}
+	return entity.Id, false, ui.starredFolders
+}
+
+// offlineTextFormat greys out text while offline, unless what it shows is
+// available offline: a directory in the library or a downloaded song
+func (ui *Ui) offlineTextFormat(text string, id string, isDirectory bool) string {
+	if !ui.connection.Offline() {
+		return text
+	}
+	if isDirectory && ui.connection.Library.Has("directory/"+id) {
+		return text
+	}
+	if !isDirectory && ui.connection.Downloads != nil && ui.connection.Downloads.Has(id) {
+		return text
+	}
+	return "[::d]" + text
 }
 
 // Just update the text of a specific row
@@ -238,7 +502,7 @@ func (ui *Ui) handleAddPlaylistSongToQueue() {
 	entity := ui.playlists[playlistIndex].Entries[entityIndex]
 	ui.addSongToQueue(&entity)
 
-	updateQueueList(ui.player, ui.queueList, ui.starIdList)
+	updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
 }
 
 func (ui *Ui) handleAddPlaylistToQueue() {
@@ -253,10 +517,12 @@ func (ui *Ui) handleAddPlaylistToQueue() {
 		ui.addSongToQueue(&entity)
 	}
 
-	updateQueueList(ui.player, ui.queueList, ui.starIdList)
+	updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
 }
 
-func (ui *Ui) handleAddSongToPlaylist(playlist *SubsonicPlaylist) {
+// handleAddEntityToPlaylist has the song, or every song in the directory,
+// highlighted in the entity list added to the playlist picked next
+func (ui *Ui) handleAddEntityToPlaylist() {
 	currentIndex := ui.entityList.GetCurrentItem()
 
 	// if we have a parent directory subtract 1 to account for the [..]
@@ -265,21 +531,30 @@ func (ui *Ui) handleAddSongToPlaylist(playlist *SubsonicPlaylist) {
 		currentIndex--
 	}
 
-	if currentIndex == -1 || len(ui.currentDirectory.Entities) < currentIndex {
+	if currentIndex == -1 || len(ui.currentDirectory.Entities) <= currentIndex {
 		return
 	}
 
 	entity := ui.currentDirectory.Entities[currentIndex]
+	entityIndex := ui.entityList.GetCurrentItem()
 
-	if !entity.IsDirectory {
-		ui.connection.AddSongToPlaylist(string(playlist.Id), entity.Id)
-	}
-	// update the playlists
-	response, err := ui.connection.GetPlaylists()
-	if err != nil {
-		ui.connection.Logger.Printf("handleAddSongToPlaylist: GetPlaylists -- %s", err.Error())
-	}
-	ui.playlists = response.Playlists.Playlists
+	ui.showAddToPlaylist(func(playlist *SubsonicPlaylist) {
+		if entityIndex+1 < ui.entityList.GetItemCount() {
+			ui.entityList.SetCurrentItem(entityIndex + 1)
+		}
+		ui.addSongsToPlaylist("handleAddEntityToPlaylist: "+entity.Title, playlist, func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error) {
+			if entity.IsDirectory {
+				return fetchDirectorySongs(ctx, connection, entity.Id)
+			}
+			return SubsonicEntities{entity}, nil
+		})
+	})
+}
+
+// setPlaylists replaces the playlists shown on the playlist page and in the
+// add to playlist list
+func (ui *Ui) setPlaylists(playlists []SubsonicPlaylist) {
+	ui.playlists = playlists
 
 	ui.playlistList.Clear()
 	ui.addToPlaylistList.Clear()
@@ -288,49 +563,199 @@ func (ui *Ui) handleAddSongToPlaylist(playlist *SubsonicPlaylist) {
 		ui.playlistList.AddItem(playlist.Name, "", 0, nil)
 		ui.addToPlaylistList.AddItem(playlist.Name, "", 0, nil)
 	}
+}
 
-	if currentIndex+1 < ui.entityList.GetItemCount() {
-		ui.entityList.SetCurrentItem(currentIndex + 1)
+// refreshLibrary brings the artists and playlists up to date in the
+// background, for when they were shown from the library
+func (ui *Ui) refreshLibrary() {
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		oldIndexes, _ := connection.CachedIndexes()
+		oldPlaylists, _ := connection.CachedPlaylists()
+
+		indexResponse, err := connection.GetIndexes(ctx)
+		if err != nil {
+			return func() { connection.Logger.PrintError(err, "refreshLibrary: GetIndexes") }
+		}
+		playlistResponse, err := connection.GetPlaylists(ctx)
+		if err != nil {
+			return func() { connection.Logger.PrintError(err, "refreshLibrary: GetPlaylists") }
+		}
+
+		return func() {
+			if ui.connection != connection {
+				return
+			}
+			if oldIndexes == nil || oldIndexes.Indexes.LastModified != indexResponse.Indexes.LastModified {
+				current := ui.artistList.GetCurrentItem()
+				ui.setIndexes(indexResponse.Indexes.Index)
+				if current < ui.artistList.GetItemCount() {
+					ui.artistList.SetCurrentItem(current)
+				}
+			}
+			if oldPlaylists == nil || !reflect.DeepEqual(oldPlaylists.Playlists, playlistResponse.Playlists) {
+				ui.setPlaylists(playlistResponse.Playlists.Playlists)
+			}
+		}
+	})
+}
+
+// refreshArtists fetches the artists again, whatever the cache says, and
+// keeps about the same one highlighted
+func (ui *Ui) refreshArtists() {
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		indexResponse, err := connection.GetIndexes(ctx)
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, "refreshArtists: GetIndexes")
+				return
+			}
+			if ui.connection != connection {
+				return
+			}
+			goBackTo := ui.artistList.GetCurrentItem()
+			connection.ForgetDirectory("")
+			ui.setIndexes(indexResponse.Indexes.Index)
+			// Try to put the user to about where they were
+			if goBackTo < ui.artistList.GetItemCount() {
+				ui.artistList.SetCurrentItem(goBackTo)
+			}
+		}
+	})
+}
+
+func fetchRandomSongs(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error) {
+	response, err := connection.GetRandomSongs(ctx)
+	if err != nil {
+		return nil, err
 	}
+	return response.RandomSongs.Song, nil
+}
+
+// refreshStarred fetches what's starred off the UI goroutine and shows it.
+// The server is the source of truth, so stars it no longer lists go.
+func (ui *Ui) refreshStarred() {
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		starred, folders, err := fetchStarred(ctx, connection)
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, "refreshStarred")
+				return
+			}
+			if ui.connection != connection {
+				return
+			}
+			// cleared in place, song handlers hold on to starIdList
+			for id := range ui.starIdList {
+				delete(ui.starIdList, id)
+			}
+			for id := range ui.starredFolders {
+				delete(ui.starredFolders, id)
+			}
+			ui.noteStarred(starred, folders)
+			ui.updateStarredLists()
+			ui.redrawLists()
+			updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
+		}
+	})
 }
 
-func (ui *Ui) addRandomSongsToQueue() {
-	response, err := ui.connection.GetRandomSongs()
-	if (err != nil) {
-		ui.connection.Logger.Printf("addRandomSongsToQueue", err.Error())
+// fetchStarred returns what's starred by ID3 id, from getStarred2, and the
+// folders starred by folder id, from getStarred
+func fetchStarred(ctx context.Context, connection *SubsonicConnection) (SubsonicStarred, SubsonicStarredFolders, error) {
+	response, err := connection.GetStarred(ctx)
+	if err != nil {
+		return SubsonicStarred{}, SubsonicStarredFolders{}, fmt.Errorf("GetStarred: %w", err)
 	}
-	for _, e := range response.RandomSongs.Song {
-		ui.addSongToQueue(&e)
+	folders, err := connection.GetStarredFolders(ctx)
+	if err != nil {
+		return SubsonicStarred{}, SubsonicStarredFolders{}, fmt.Errorf("GetStarredFolders: %w", err)
 	}
+	return response.Starred, folders.StarredFolders, nil
 }
 
-func (ui *Ui) addStarredToList() {
-	response, err := ui.connection.GetStarred()
-	if (err != nil) {
-		ui.connection.Logger.Printf("addStarredToList", err.Error())
+// noteStarred remembers what's starred, for the starred page and the stars in
+// the lists
+func (ui *Ui) noteStarred(starred SubsonicStarred, folders SubsonicStarredFolders) {
+	ui.starred = starred
+	for _, artist := range folders.Artists {
+		ui.starredFolders[artist.Id] = struct{}{}
+	}
+	for _, album := range folders.Albums {
+		ui.starredFolders[album.Id] = struct{}{}
 	}
-	for _, e := range response.Starred.Song {
-		// We're storing empty struct as values as we only want the indexes
-		// It's faster having direct index access instead of looping through array values
+
+	// We're storing empty struct as values as we only want the indexes
+	// It's faster having direct index access instead of looping through array values
+	for _, e := range starred.Songs {
 		ui.starIdList[e.Id] = struct{}{}
 	}
+	for _, album := range starred.Albums {
+		ui.starIdList[album.Id] = struct{}{}
+	}
+	for _, artist := range starred.Artists {
+		ui.starIdList[artist.Id] = struct{}{}
+	}
 }
 
-func (ui *Ui) addDirectoryToQueue(entity *SubsonicEntity) {
-	response, err := ui.connection.GetMusicDirectory(entity.Id)
+// The fetch* functions collect songs to queue. They make requests off the UI
+// goroutine, see enqueueInBackground, so they get the connection to use rather
+// than reading ui.connection, which the UI may replace meanwhile.
+
+// fetchAlbumSongs returns every song of an album, looked up by its ID3 id
+func fetchAlbumSongs(ctx context.Context, connection *SubsonicConnection, id string) (SubsonicEntities, error) {
+	response, err := connection.GetAlbum(ctx, id)
 	if err != nil {
-		ui.connection.Logger.Printf("addDirectoryToQueue: GetMusicDirectory %s -- %s", entity.Id, err.Error())
-		return
+		return nil, fmt.Errorf("GetAlbum %s: %w", id, err)
 	}
+	return response.Album.Songs, nil
+}
 
-	sort.Sort(response.Directory.Entities)
-	for _, e := range response.Directory.Entities {
-		if e.IsDirectory {
-			ui.addDirectoryToQueue(&e)
-		} else {
-			ui.addSongToQueue(&e)
+// fetchArtistSongs returns every song on every album of an artist, looked up
+// by its ID3 id
+func fetchArtistSongs(ctx context.Context, connection *SubsonicConnection, id string) (SubsonicEntities, error) {
+	response, err := connection.GetArtist(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("GetArtist %s: %w", id, err)
+	}
+
+	var songs SubsonicEntities
+	for _, album := range response.Artist.Albums {
+		albumSongs, err := fetchAlbumSongs(ctx, connection, album.Id)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, albumSongs...)
+	}
+	return songs, nil
+}
+
+// fetchDirectorySongs returns every song in a directory and the directories
+// below it
+func fetchDirectorySongs(ctx context.Context, connection *SubsonicConnection, id string) (SubsonicEntities, error) {
+	response, err := connection.GetMusicDirectory(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("GetMusicDirectory %s: %w", id, err)
+	}
+
+	// the response may be the library's, which is shared, sort a copy
+	entities := append(SubsonicEntities(nil), response.Directory.Entities...)
+	sort.Sort(entities)
+	var songs SubsonicEntities
+	for _, e := range entities {
+		if !e.IsDirectory {
+			songs = append(songs, e)
+			continue
+		}
+		subSongs, err := fetchDirectorySongs(ctx, connection, e.Id)
+		if err != nil {
+			return nil, err
 		}
+		songs = append(songs, subSongs...)
 	}
+	return songs, nil
 }
 
 func (ui *Ui) search() {
@@ -375,64 +800,99 @@ func (ui *Ui) searchPrev() {
 }
 
 func (ui *Ui) addSongToQueue(entity *SubsonicEntity) {
-	uri := ui.connection.GetPlayUrl(entity)
-
-	var artist string
-	if ui.currentDirectory == nil {
-		artist = entity.Artist
-	} else {
-		stringOr(entity.Artist, ui.currentDirectory.Name)
+	var fallbackArtist string
+	if ui.currentDirectory != nil {
+		fallbackArtist = ui.currentDirectory.Name
 	}
 
-	var id = entity.Id
-
+	ui.noteRatings(SubsonicEntities{*entity})
+	ui.player.Enqueue(ui.makeQueueItem(entity, fallbackArtist))
+}
 
-	queueItem := QueueItem{
-		id,
-		uri,
-		entity.getSongTitle(),
-		artist,
-		entity.Duration,
+// makeQueueItem builds the queue entry for a song. fallbackArtist is used when
+// the song doesn't carry an artist tag, e.g. the name of the directory it's in.
+func (ui *Ui) makeQueueItem(entity *SubsonicEntity, fallbackArtist string) QueueItem {
+	return QueueItem{
+		Id:       entity.Id,
+		Uri:      ui.connection.GetPlayUrl(entity),
+		Title:    entity.getSongTitle(),
+		Artist:   stringOr(entity.Artist, fallbackArtist),
+		Album:    entity.Album,
+		Year:     entity.Year,
+		CoverArt: entity.CoverArt,
+		BitRate:  entity.BitRate,
+		Suffix:   entity.Suffix,
+		Duration: entity.Duration,
 	}
-	ui.player.Queue = append(ui.player.Queue, queueItem)
 }
 
 func (ui *Ui) newPlaylist(name string) {
-	response, err := ui.connection.CreatePlaylist(name)
-	if err != nil {
-		ui.connection.Logger.Printf("newPlaylist: CreatePlaylist %s -- %s", name, err.Error())
-		return
-	}
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		response, err := connection.CreatePlaylist(ctx, name)
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, "newPlaylist: CreatePlaylist %s", name)
+				return
+			}
+			if ui.connection != connection {
+				return
+			}
 
-	ui.playlists = append(ui.playlists, response.Playlist)
+			ui.playlists = append(ui.playlists, response.Playlist)
 
-	ui.playlistList.AddItem(response.Playlist.Name, "", 0, nil)
-	ui.addToPlaylistList.AddItem(response.Playlist.Name, "", 0, nil)
+			ui.playlistList.AddItem(response.Playlist.Name, "", 0, nil)
+			ui.addToPlaylistList.AddItem(response.Playlist.Name, "", 0, nil)
+		}
+	})
 }
 
 func (ui *Ui) deletePlaylist(index int) {
-	if index == -1 || len(ui.playlists) < index {
+	if index < 0 || len(ui.playlists) <= index {
 		return
 	}
 
 	playlist := ui.playlists[index]
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		err := connection.DeletePlaylist(ctx, string(playlist.Id))
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, "deletePlaylist: DeletePlaylist %s", playlist.Name)
+				return
+			}
+			if ui.connection != connection {
+				return
+			}
 
-	if index == 0 {
-		ui.playlistList.SetCurrentItem(1)
-	}
+			// the list may have changed while the request was out
+			index := -1
+			for i, other := range ui.playlists {
+				if other.Id == playlist.Id {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				return
+			}
+			if index == 0 {
+				ui.playlistList.SetCurrentItem(1)
+			}
 
-	// Removes item with specified index
-	ui.playlists = append(ui.playlists[:index], ui.playlists[index+1:]...)
+			// Removes item with specified index
+			ui.playlists = append(ui.playlists[:index], ui.playlists[index+1:]...)
 
-	ui.playlistList.RemoveItem(index)
-	ui.addToPlaylistList.RemoveItem(index)
-	ui.connection.DeletePlaylist(string(playlist.Id))
+			ui.playlistList.RemoveItem(index)
+			ui.addToPlaylistList.RemoveItem(index)
+		}
+	})
 }
 
-func makeSongHandler(id string, uri string, title string, artist string, duration int, player *Player, queueList *tview.List, starIdList map[string]struct{}) func() {
+func makeSongHandler(queueItem QueueItem, player *Player, queueList *tview.List, starIdList map[string]struct{}, ratings map[string]int) func() {
 	return func() {
-		player.Play(id, uri, title, artist, duration)
-		updateQueueList(player, queueList, starIdList)
+		player.Play(queueItem)
+		updateQueueList(player, queueList, starIdList, ratings)
 	}
 }
 
@@ -458,6 +918,13 @@ func createUi(_ *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection *Sub
 	addToPlaylistList := tview.NewList().ShowSecondaryText(false)
 	// songs in the selected playlist
 	selectedPlaylist := tview.NewList().ShowSecondaryText(false)
+	// starred artists, albums and songs
+	starredArtistList := tview.NewList().ShowSecondaryText(false).
+		SetSelectedFocusOnly(true)
+	starredAlbumList := tview.NewList().ShowSecondaryText(false).
+		SetSelectedFocusOnly(true)
+	starredSongList := tview.NewList().ShowSecondaryText(false).
+		SetSelectedFocusOnly(true)
 	// status text at the top
 	startStopStatus := tview.NewTextView().SetText("[::b]stmp: [red]stopped").
 		SetTextAlign(tview.AlignLeft).
@@ -472,18 +939,29 @@ func createUi(_ *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection *Sub
 		SetLabel("Playlist name:").
 		SetFieldWidth(50)
 	logs := tview.NewList().ShowSecondaryText(false)
+	// errors flash here, in place of the page name
+	toast := tview.NewTextView().
+		SetTextAlign(tview.AlignCenter).
+		SetDynamicColors(true)
+	spinner := tview.NewTextView().
+		SetTextAlign(tview.AlignRight)
+	offlineStatus := tview.NewTextView().
+		SetTextAlign(tview.AlignRight).
+		SetDynamicColors(true)
+	// above the log, scrobbles waiting for the server
+	scrobbleStatus := tview.NewTextView().
+		SetDynamicColors(true)
+	rateList := tview.NewList().ShowSecondaryText(false)
 	var currentDirectory *SubsonicDirectory
 	var artistIdList []string
 	// Stores the song IDs
 	var starIdList = map[string]struct{}{}
 
-	// create reused timer to scrobble after delay
-	scrobbleTimer := time.NewTimer(0)
-	if !scrobbleTimer.Stop() {
-		<-scrobbleTimer.C
-	}
+	ctx, cancel := context.WithCancel(context.Background())
 
 	ui := Ui{
+		ctx:               ctx,
+		cancel:            cancel,
 		app:               app,
 		pages:             pages,
 		entityList:        entityList,
@@ -491,26 +969,40 @@ func createUi(_ *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection *Sub
 		playlistList:      playlistList,
 		addToPlaylistList: addToPlaylistList,
 		selectedPlaylist:  selectedPlaylist,
+		starredArtistList: starredArtistList,
+		starredAlbumList:  starredAlbumList,
+		starredSongList:   starredSongList,
 		newPlaylistInput:  newPlaylistInput,
 		startStopStatus:   startStopStatus,
 		currentPage:       currentPage,
 		playerStatus:      playerStatus,
 		logList:           logs,
+		toast:             toast,
+		spinner:           spinner,
+		offlineStatus:     offlineStatus,
+		scrobbleStatus:    scrobbleStatus,
 		currentDirectory:  currentDirectory,
 		artistIdList:      artistIdList,
 		starIdList:        starIdList,
+		starredFolders:    map[string]struct{}{},
+		ratings:           map[string]int{},
+		artistInfoCache:   map[string]SubsonicArtistInfo{},
+		showArtistInfo:    true,
+		rateList:          rateList,
 		playlists:         *playlists,
 		connection:        connection,
 		player:            player,
-		scrobbleTimer:     scrobbleTimer,
 		currentPlaylistIndex: 0,
 	}
 
-	ui.addStarredToList()
-
 	go func() {
 		for {
 			select {
+			case msg := <-connection.Logger.toasts:
+				ui.app.QueueUpdateDraw(func() {
+					ui.showToast(msg)
+				})
+
 			case msg := <-connection.Logger.prints:
 				ui.app.QueueUpdate(func() {
 					ui.logList.AddItem(msg, "", 0, nil)
@@ -519,21 +1011,6 @@ func createUi(_ *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection *Sub
 						ui.logList.RemoveItem(0)
 					}
 				})
-
-			case <-scrobbleTimer.C:
-				// scrobble submission delay elapsed
-				paused, err := ui.player.IsPaused()
-				connection.Logger.Printf("scrobbler event: paused %v, err %v, qlen %d", paused, err, len(ui.player.Queue))
-				isPlaying := err == nil && !paused
-				if len(ui.player.Queue) > 0 && isPlaying {
-					// it's still playing, submit it
-					currentSong := ui.player.Queue[0]
-					if track := ui.player.CurrentTrack(); track != nil {
-            		ui.connection.ScrobbleSubmission(track.Id, true)
-        			} else {
-					ui.connection.ScrobbleSubmission(currentSong.Id, true)
-						}
-				}
 			}
 		}
 	}()
@@ -541,15 +1018,55 @@ func createUi(_ *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection *Sub
 	return &ui
 }
 
-func (ui *Ui) createBrowserPage(titleFlex *tview.Flex, indexes *[]SubsonicIndex) (*tview.Flex, tview.Primitive) {
-	// artist list, used to map the index of
-	ui.artistList = tview.NewList().ShowSecondaryText(false)
-	for _, index := range *indexes {
+// toastDuration is how long an error stays on the status line
+const toastDuration = 5 * time.Second
+
+// showToast replaces the page name in the title row with msg for a few seconds
+func (ui *Ui) showToast(msg string) {
+	ui.toastCount++
+	toastId := ui.toastCount
+
+	ui.toast.SetText("[red::b]" + tview.Escape(msg))
+	ui.setTitleCenter(ui.toast)
+
+	time.AfterFunc(toastDuration, func() {
+		ui.app.QueueUpdateDraw(func() {
+			// a newer toast owns the title row now
+			if ui.toastCount == toastId {
+				ui.setTitleCenter(ui.currentPage)
+			}
+		})
+	})
+}
+
+func (ui *Ui) setTitleCenter(center tview.Primitive) {
+	ui.titleFlex.Clear().
+		AddItem(ui.startStopStatus, 0, 1, false).
+		AddItem(center, 0, 1, false).
+		AddItem(ui.playerStatus, 0, 1, false).
+		AddItem(ui.offlineStatus, 8, 0, false).
+		AddItem(ui.spinner, 2, 0, false)
+}
+
+// setIndexes fills the artist list
+func (ui *Ui) setIndexes(indexes []SubsonicIndex) {
+	ui.artistList.Clear()
+	ui.artistIdList = nil
+	ui.artistNameList = nil
+	for _, index := range indexes {
 		for _, artist := range index.Artists {
-			ui.artistList.AddItem(artist.Name, "", 0, nil)
+			// adding the first item fires the changed func, which needs the id
 			ui.artistIdList = append(ui.artistIdList, artist.Id)
+			ui.artistNameList = append(ui.artistNameList, artist.Name)
+			ui.artistList.AddItem(ui.artistTextFormat(len(ui.artistIdList)-1), "", 0, nil)
 		}
 	}
+}
+
+func (ui *Ui) createBrowserPage(titleFlex *tview.Flex, indexes *[]SubsonicIndex) (*tview.Flex, tview.Primitive) {
+	// artist list, used to map the index of
+	ui.artistList = tview.NewList().ShowSecondaryText(false)
+	ui.setIndexes(*indexes)
 
 	ui.searchField = tview.NewInputField().
 		SetLabel("Search:").
@@ -563,13 +1080,19 @@ func (ui *Ui) createBrowserPage(titleFlex *tview.Flex, indexes *[]SubsonicIndex)
 		ui.app.SetFocus(ui.artistList)
 	})
 
-	artistFlex := tview.NewFlex().SetDirection(tview.FlexColumn).
+	ui.browserColumns = tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(ui.artistList, 0, 1, true).
 		AddItem(ui.entityList, 0, 1, false)
 
+	// biography and similar artists for the highlighted artist
+	ui.createArtistInfoPane()
+	if ui.showArtistInfo {
+		ui.browserColumns.AddItem(ui.artistInfoFlex, 0, 1, false)
+	}
+
 	browserFlex := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(titleFlex, 1, 0, false).
-		AddItem(artistFlex, 0, 1, true).
+		AddItem(ui.browserColumns, 0, 1, true).
 		AddItem(ui.searchField, 1, 0, false)
 
 	// going right from the artist list should focus the album/song list
@@ -587,32 +1110,26 @@ func (ui *Ui) createBrowserPage(titleFlex *tview.Flex, indexes *[]SubsonicIndex)
 		case keybind("searchPrev"):
 			ui.searchPrev()
 			return nil
-		case keybind("refresh"):
-			goBackTo := ui.artistList.GetCurrentItem()
-			// REFRESH artists
-			indexResponse, err := ui.connection.GetIndexes()
-			if err != nil {
-				ui.connection.Logger.Printf("Error fetching indexes from server: %s\n", err)
-				return event
-			}
-			ui.artistList.Clear()
-			ui.connection.directoryCache = make(map[string]SubsonicResponse)
-			for _, index := range indexResponse.Indexes.Index {
-				for _, artist := range index.Artists {
-					ui.artistList.AddItem(artist.Name, "", 0, nil)
-					ui.artistIdList = append(ui.artistIdList, artist.Id)
-				}
-			}
-			// Try to put the user to about where they were
-			if goBackTo < ui.artistList.GetItemCount() {
-				ui.artistList.SetCurrentItem(goBackTo)
+		case keybind("star"):
+			ui.handleToggleArtistStar()
+			return nil
+		case keybind("artistInfo"):
+			ui.toggleArtistInfo()
+			return nil
+		case keybind("playTopSongs"):
+			if index := ui.artistList.GetCurrentItem(); index >= 0 && index < len(ui.artistNameList) {
+				ui.handlePlayTopSongs(ui.artistNameList[index])
 			}
+			return nil
+		case keybind("refresh"):
+			ui.refreshArtists()
 		}
 		return event
 	})
 
 	ui.artistList.SetChangedFunc(func(index int, _ string, _ string, _ rune) {
 		ui.handleEntitySelected(ui.artistIdList[index])
+		ui.handleArtistHighlighted(index)
 	})
 
 	for _, playlist := range ui.playlists {
@@ -629,16 +1146,14 @@ func (ui *Ui) createBrowserPage(titleFlex *tview.Flex, indexes *[]SubsonicIndex)
 
 	ui.addToPlaylistList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event.Key() == tcell.KeyEscape {
-			ui.pages.HidePage("addToPlaylist")
-			ui.pages.SwitchToPage("browser")
-			ui.app.SetFocus(ui.entityList)
+			ui.hideAddToPlaylist()
 		} else if event.Key() == tcell.KeyEnter {
-			playlist := ui.playlists[ui.addToPlaylistList.GetCurrentItem()]
-			ui.handleAddSongToPlaylist(&playlist)
-
-			ui.pages.HidePage("addToPlaylist")
-			ui.pages.SwitchToPage("browser")
-			ui.app.SetFocus(ui.entityList)
+			index := ui.addToPlaylistList.GetCurrentItem()
+			ui.hideAddToPlaylist()
+			if index < len(ui.playlists) && ui.addToPlaylistSelected != nil {
+				playlist := ui.playlists[index]
+				ui.addToPlaylistSelected(&playlist)
+			}
 		}
 		return event
 	})
@@ -648,6 +1163,14 @@ func (ui *Ui) createBrowserPage(titleFlex *tview.Flex, indexes *[]SubsonicIndex)
 			ui.app.SetFocus(ui.artistList)
 			return nil
 		}
+		if keyName(event) == keybind("right") && ui.showArtistInfo {
+			ui.app.SetFocus(ui.similarArtistList)
+			return nil
+		}
+		if keyName(event) == keybind("artistInfo") {
+			ui.toggleArtistInfo()
+			return nil
+		}
 		if keyName(event) == keybind("add") {
 			ui.handleAddEntityToQueue()
 			return nil
@@ -656,10 +1179,13 @@ func (ui *Ui) createBrowserPage(titleFlex *tview.Flex, indexes *[]SubsonicIndex)
 			ui.handleToggleEntityStar()
 			return nil
 		}
+		if keyName(event) == keybind("download") {
+			ui.handleDownloadEntity()
+			return nil
+		}
 		// only makes sense to add to a playlist if there are playlists
 		if keyName(event) == keybind("addToPlaylist") && ui.playlistList.GetItemCount() > 0 {
-			ui.pages.ShowPage("addToPlaylist")
-			ui.app.SetFocus(ui.addToPlaylistList)
+			ui.handleAddEntityToPlaylist()
 			return nil
 		}
 		// REFRESH only the artist
@@ -667,7 +1193,7 @@ func (ui *Ui) createBrowserPage(titleFlex *tview.Flex, indexes *[]SubsonicIndex)
 			artistIdx := ui.artistList.GetCurrentItem()
 			entity := ui.artistIdList[artistIdx]
 			//ui.logger.Printf("refreshing artist idx %d, entity %s (%s)", artistIdx, entity, ui.connection.directoryCache[entity].Directory.Name)
-			delete(ui.connection.directoryCache, entity)
+			ui.connection.ForgetDirectory(entity)
 			ui.handleEntitySelected(ui.artistIdList[artistIdx])
 			return nil
 		}
@@ -688,6 +1214,15 @@ func (ui *Ui) createQueuePage(titleFlex *tview.Flex) *tview.Flex {
 		} else if keyName(event) == keybind("star") {
 			ui.handleToggleStar()
 			return nil
+		} else if keyName(event) == keybind("saveQueue") {
+			ui.showSaveQueue()
+			return nil
+		} else if keyName(event) == keybind("addToPlaylist") && ui.playlistList.GetItemCount() > 0 {
+			ui.handleAddQueueItemToPlaylist()
+			return nil
+		} else if keyName(event) == keybind("addCurrentToPlaylist") && ui.playlistList.GetItemCount() > 0 {
+			ui.handleAddCurrentToPlaylist()
+			return nil
 		}
 
 		return event
@@ -696,6 +1231,181 @@ func (ui *Ui) createQueuePage(titleFlex *tview.Flex) *tview.Flex {
 	return queueFlex
 }
 
+func (ui *Ui) createStarredPage(titleFlex *tview.Flex) *tview.Flex {
+	ui.starredArtistList.SetBorder(true).SetTitle("Artists")
+	ui.starredAlbumList.SetBorder(true).SetTitle("Albums")
+	ui.starredSongList.SetBorder(true).SetTitle("Songs")
+
+	ui.updateStarredLists()
+
+	starredColFlex := tview.NewFlex().SetDirection(tview.FlexColumn).
+		AddItem(ui.starredArtistList, 0, 1, true).
+		AddItem(ui.starredAlbumList, 0, 1, false).
+		AddItem(ui.starredSongList, 0, 1, false)
+
+	starredFlex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(titleFlex, 1, 0, false).
+		AddItem(starredColFlex, 0, 1, true)
+
+	columns := []*tview.List{ui.starredArtistList, ui.starredAlbumList, ui.starredSongList}
+	for i, list := range columns {
+		column := i
+		list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+			switch keyName(event) {
+			case keybind("left"):
+				if column > 0 {
+					ui.app.SetFocus(columns[column-1])
+				}
+				return nil
+			case keybind("right"):
+				if column < len(columns)-1 {
+					ui.app.SetFocus(columns[column+1])
+				}
+				return nil
+			case keybind("add"):
+				ui.handleAddStarredToQueue(column)
+				return nil
+			case keybind("addAllStarred"):
+				ui.handleAddAllStarredToQueue()
+				return nil
+			case keybind("star"):
+				ui.handleUnstar(column)
+				return nil
+			case keybind("refresh"):
+				ui.refreshStarred()
+				return nil
+			}
+			return event
+		})
+	}
+
+	return starredFlex
+}
+
+// updateStarredLists redraws the starred page from ui.starred
+func (ui *Ui) updateStarredLists() {
+	ui.starredArtistList.Clear()
+	for _, artist := range ui.starred.Artists {
+		id := artist.Id
+		ui.starredArtistList.AddItem(artist.Name, "", 0, func() {
+			ui.enqueueInBackground("playStarredArtist", true, func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error) {
+				return fetchArtistSongs(ctx, connection, id)
+			})
+		})
+	}
+
+	ui.starredAlbumList.Clear()
+	for _, album := range ui.starred.Albums {
+		id := album.Id
+		ui.starredAlbumList.AddItem(album.Name+" - "+album.Artist, "", 0, func() {
+			ui.enqueueInBackground("playStarredAlbum", true, func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error) {
+				return fetchAlbumSongs(ctx, connection, id)
+			})
+		})
+	}
+
+	ui.starredSongList.Clear()
+	ui.noteRatings(ui.starred.Songs)
+	for _, entity := range ui.starred.Songs {
+		title := ui.entityTextFormat(entity)
+		ui.starredSongList.AddItem(title, "", 0, makeSongHandler(ui.makeQueueItem(&entity, ""),
+			ui.player, ui.queueList, ui.starIdList, ui.ratings))
+	}
+}
+
+func (ui *Ui) handleAddStarredToQueue(column int) {
+	switch column {
+	case 0:
+		index := ui.starredArtistList.GetCurrentItem()
+		if index == -1 || len(ui.starred.Artists) <= index {
+			return
+		}
+		id := ui.starred.Artists[index].Id
+		ui.enqueueInBackground("handleAddStarredToQueue", false, func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error) {
+			return fetchArtistSongs(ctx, connection, id)
+		})
+		if index+1 < ui.starredArtistList.GetItemCount() {
+			ui.starredArtistList.SetCurrentItem(index + 1)
+		}
+	case 1:
+		index := ui.starredAlbumList.GetCurrentItem()
+		if index == -1 || len(ui.starred.Albums) <= index {
+			return
+		}
+		id := ui.starred.Albums[index].Id
+		ui.enqueueInBackground("handleAddStarredToQueue", false, func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error) {
+			return fetchAlbumSongs(ctx, connection, id)
+		})
+		if index+1 < ui.starredAlbumList.GetItemCount() {
+			ui.starredAlbumList.SetCurrentItem(index + 1)
+		}
+	case 2:
+		index := ui.starredSongList.GetCurrentItem()
+		if index == -1 || len(ui.starred.Songs) <= index {
+			return
+		}
+		ui.addSongToQueue(&ui.starred.Songs[index])
+		if index+1 < ui.starredSongList.GetItemCount() {
+			ui.starredSongList.SetCurrentItem(index + 1)
+		}
+		updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
+	}
+}
+
+// handleAddAllStarredToQueue queues every starred song, album and artist, in
+// that order
+func (ui *Ui) handleAddAllStarredToQueue() {
+	starred := ui.starred
+	ui.enqueueInBackground("handleAddAllStarredToQueue", false, func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error) {
+		songs := append(SubsonicEntities{}, starred.Songs...)
+		for _, album := range starred.Albums {
+			albumSongs, err := fetchAlbumSongs(ctx, connection, album.Id)
+			if err != nil {
+				return nil, err
+			}
+			songs = append(songs, albumSongs...)
+		}
+		for _, artist := range starred.Artists {
+			artistSongs, err := fetchArtistSongs(ctx, connection, artist.Id)
+			if err != nil {
+				return nil, err
+			}
+			songs = append(songs, artistSongs...)
+		}
+		return songs, nil
+	})
+}
+
+func (ui *Ui) handleUnstar(column int) {
+	var id string
+	toggle := (*SubsonicConnection).ToggleStar
+	switch column {
+	case 0:
+		index := ui.starredArtistList.GetCurrentItem()
+		if index == -1 || len(ui.starred.Artists) <= index {
+			return
+		}
+		id, toggle = ui.starred.Artists[index].Id, (*SubsonicConnection).ToggleArtistStar
+	case 1:
+		index := ui.starredAlbumList.GetCurrentItem()
+		if index == -1 || len(ui.starred.Albums) <= index {
+			return
+		}
+		id, toggle = ui.starred.Albums[index].Id, (*SubsonicConnection).ToggleAlbumStar
+	case 2:
+		index := ui.starredSongList.GetCurrentItem()
+		if index == -1 || len(ui.starred.Songs) <= index {
+			return
+		}
+		id = ui.starred.Songs[index].Id
+	default:
+		return
+	}
+
+	// reload rather than patching the lists, the server is the source of truth
+	ui.toggleStarInBackground("handleUnstar", id, ui.starIdList, toggle, ui.refreshStarred)
+}
+
 func (ui *Ui) createPlaylistPage(titleFlex *tview.Flex) (*tview.Flex, tview.Primitive) {
 	//add the playlists
 	for _, playlist := range ui.playlists {
@@ -742,6 +1452,10 @@ func (ui *Ui) createPlaylistPage(titleFlex *tview.Flex) (*tview.Flex, tview.Prim
 			ui.handleAddPlaylistToQueue()
 			return nil
 		}
+		if keyName(event) == keybind("download") {
+			ui.handleDownloadPlaylist()
+			return nil
+		}
 		if keyName(event) == keybind("newPlaylist") {
 			playlistFlex.AddItem(ui.newPlaylistInput, 0, 1, true)
 			ui.app.SetFocus(ui.newPlaylistInput)
@@ -761,6 +1475,23 @@ func (ui *Ui) createPlaylistPage(titleFlex *tview.Flex) (*tview.Flex, tview.Prim
 			ui.handleAddPlaylistSongToQueue()
 			return nil
 		}
+		switch keyName(event) {
+		case keybind("removeFromPlaylist"):
+			ui.handleRemoveFromPlaylist()
+			return nil
+		case keybind("moveUp"):
+			ui.handleMoveInPlaylist(-1)
+			return nil
+		case keybind("moveDown"):
+			ui.handleMoveInPlaylist(1)
+			return nil
+		case keybind("editPlaylist"):
+			ui.showEditPlaylist()
+			return nil
+		case keybind("togglePublic"):
+			ui.handleTogglePlaylistPublic()
+			return nil
+		}
 		return event
 	})
 
@@ -798,33 +1529,60 @@ func (ui *Ui) createPlaylistPage(titleFlex *tview.Flex) (*tview.Flex, tview.Prim
 	return playlistFlex, deletePlaylistModal
 }
 
-func InitGui(indexes *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection *SubsonicConnection, player *Player) *Ui {
+func InitGui(indexes *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection *SubsonicConnection, player *Player, profile string, scrobblers []*ScrobbleTarget, history *History) *Ui {
 	ui := createUi(indexes, playlists, connection, player)
+	ui.profile = profile
+	ui.scrobblers = scrobblers
+	ui.history = history
 
 
 	// create components shared by pages
 
 	//title row flex
-	titleFlex := tview.NewFlex().SetDirection(tview.FlexColumn).
-		AddItem(ui.startStopStatus, 0, 1, false).
-		AddItem(ui.currentPage, 0, 1, false).
-		AddItem(ui.playerStatus, 0, 1, false)
+	titleFlex := tview.NewFlex().SetDirection(tview.FlexColumn)
+	ui.titleFlex = titleFlex
+	ui.setTitleCenter(ui.currentPage)
 
 	browserFlex, addToPlaylistModal := ui.createBrowserPage(titleFlex, indexes)
 	queueFlex := ui.createQueuePage(titleFlex)
 	playlistFlex, deletePlaylistModal := ui.createPlaylistPage(titleFlex)
+	starredFlex := ui.createStarredPage(titleFlex)
+	rateModal := ui.createRatePage()
+	playlistFormModal := ui.createPlaylistFormPage()
+	nowPlayingFlex := ui.createNowPlayingPage(titleFlex)
+	lyricsFlex := ui.createLyricsPage(titleFlex)
+	serversFlex := ui.createServersPage(titleFlex)
+	downloadsFlex := ui.createDownloadsPage(titleFlex)
+	historyFlex := ui.createHistoryPage(titleFlex)
+	statsFlex := ui.createStatsPage(titleFlex)
 	logListFlex := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(titleFlex, 1, 0, false).
+		AddItem(ui.scrobbleStatus, 1, 0, false).
 		AddItem(ui.logList, 0, 1, true)
 
 	// handle
-	go ui.handleMpvEvents()
+	go ui.handlePlayerEvents()
+	go runHistory(ui.ctx, ui.player, ui.history)
+	ui.followHistory()
+	ui.startScrobbler()
+	ui.followOffline()
+	ui.refreshLibrary()
+	ui.refreshStarred()
 
 	ui.pages.AddPage("browser", browserFlex, true, true).
 		AddPage("queue", queueFlex, true, false).
 		AddPage("playlists", playlistFlex, true, false).
+		AddPage("starred", starredFlex, true, false).
+		AddPage("nowplaying", nowPlayingFlex, true, false).
+		AddPage("lyrics", lyricsFlex, true, false).
+		AddPage("servers", serversFlex, true, false).
+		AddPage("downloads", downloadsFlex, true, false).
+		AddPage("history", historyFlex, true, false).
+		AddPage("stats", statsFlex, true, false).
 		AddPage("addToPlaylist", addToPlaylistModal, true, false).
 		AddPage("deletePlaylist", deletePlaylistModal, true, false).
+		AddPage("playlistForm", playlistFormModal, true, false).
+		AddPage("rate", rateModal, true, false).
 		AddPage("log", logListFlex, true, false)
 
 	if len(ui.playlists) > 0 && ui.player != nil {
@@ -841,7 +1599,7 @@ func InitGui(indexes *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection
     ui.handleAddPlaylistToQueue()
 
     // refresh the queue list UI so it shows up immediately
-    updateQueueList(ui.player, ui.queueList, ui.starIdList)
+    updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
 
     // switch the visible page to queue
     ui.pages.SwitchToPage("queue")
@@ -852,7 +1610,7 @@ func InitGui(indexes *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection
 	ui.pages.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		// we don't want any of these firing if we're trying to add a new playlist
 		focused := ui.app.GetFocus()
-		if focused == ui.newPlaylistInput || focused == ui.searchField {
+		if focused == ui.newPlaylistInput || focused == ui.searchField || focused == ui.rateList || ui.playlistForm.HasFocus() {
 			return event
 		}
 
@@ -866,22 +1624,55 @@ func InitGui(indexes *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection
 		case keybind("pagePlaylists"):
 			ui.pages.SwitchToPage("playlists")
 			ui.currentPage.SetText("Playlists")
+		case keybind("pageStarred"):
+			ui.pages.SwitchToPage("starred")
+			ui.currentPage.SetText("Starred")
+		case keybind("pageNowPlaying"):
+			ui.pages.SwitchToPage("nowplaying")
+			ui.currentPage.SetText("Now Playing")
+		case keybind("pageLyrics"):
+			ui.pages.SwitchToPage("lyrics")
+			ui.currentPage.SetText("Lyrics")
+		case keybind("pageDownloads"):
+			ui.pages.SwitchToPage("downloads")
+			ui.currentPage.SetText("Downloads")
+		case keybind("pageServers"):
+			ui.pages.SwitchToPage("servers")
+			ui.currentPage.SetText("Servers")
+		case keybind("pageHistory"):
+			ui.pages.SwitchToPage("history")
+			ui.currentPage.SetText("History")
+		case keybind("pageStats"):
+			ui.pages.SwitchToPage("stats")
+			ui.currentPage.SetText("Stats")
 		case keybind("pageLog"):
 			ui.pages.SwitchToPage("log")
 			ui.currentPage.SetText("Log")
 		case keybind("quit"):
-			ui.player.EventChannel <- nil
-			ui.player.Instance.TerminateDestroy()
+			ui.cancel()
+			ui.connection.Close()
+			for _, target := range ui.scrobblers {
+				target.Queue.Close()
+			}
+			ui.player.Close()
 			ui.app.Stop()
+		case keybind("rate"):
+			ui.showRatePage(ui.selectedSongId())
+			return nil
+		case keybind("rateCurrent"):
+			if track := ui.player.CurrentTrack(); track != nil {
+				ui.showRatePage(track.Id)
+			}
+			return nil
 		case keybind("addRandomSongs"):
 			ui.handleAddRandomSongs()
 		case keybind("clearQueue"):
-			ui.player.Queue = make([]QueueItem, 0)
+			ui.player.ReplaceQueue(nil)
 			err := ui.player.Stop()
 			if err != nil {
 				ui.connection.Logger.Printf("InitGui: Stop -- %s", err.Error())
 			}
-			updateQueueList(ui.player, ui.queueList, ui.starIdList)
+			updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
 		case keybind("playPause"):
 			status, err := ui.player.Pause()
 			if err != nil {
@@ -926,7 +1717,7 @@ func InitGui(indexes *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection
 			if err := ui.player.AdjustVolume(5); err != nil {
 				ui.connection.Logger.Printf("InitGui: AdjustVolume %d -- %s", 5, err.Error())
 			}
-			updateQueueList(ui.player, ui.queueList, ui.starIdList)
+			updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
 			return nil
 		case keybind("seekForward"):
 			if err := ui.player.Seek(10); err != nil {
@@ -954,6 +1745,10 @@ func InitGui(indexes *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection
 		return event
 	})
 
+	// kitty and sixel cover art is written straight to the terminal once
+	// tview is done drawing
+	ui.app.SetAfterDrawFunc(ui.coverArt.Flush)
+
 	if err := ui.app.SetRoot(ui.pages, true).SetFocus(ui.pages).EnableMouse(true).Run(); err != nil {
 		panic(err)
 	}
@@ -962,14 +1757,22 @@ func InitGui(indexes *[]SubsonicIndex, playlists *[]SubsonicPlaylist, connection
 }
 
 
-func queueListTextFormat(queueItem QueueItem, starredItems map[string]struct{} ) string {
+func queueListTextFormat(queueItem QueueItem, starredItems map[string]struct{}, ratings map[string]int) string {
 	min, sec := iSecondsToMinAndSec(queueItem.Duration)
 	var star = ""
 	_, hasStar := starredItems[queueItem.Id]
 	if hasStar {
 		star = " [red]♥"
 	}
-	return fmt.Sprintf("%s - %s - %02d:%02d %s", queueItem.Title, queueItem.Artist, min, sec,star)
+	return fmt.Sprintf("%s - %s - %02d:%02d %s%s", queueItem.Title, queueItem.Artist, min, sec, star, ratingTextFormat(ratings[queueItem.Id]))
+}
+
+// ratingTextFormat renders a 1-5 rating as stars, or nothing if unrated
+func ratingTextFormat(rating int) string {
+	if rating <= 0 {
+		return ""
+	}
+	return " [yellow]" + strings.Repeat("★", rating) + strings.Repeat("☆", 5-rating)
 }
 
 // Just update the text of a specific row
@@ -977,25 +1780,10 @@ func updateQueueListItem(queueList *tview.List, id int, text string) {
 	queueList.SetItemText(id, text, "")
 }
 
-func (p *Player) PlayPreviousTrack() error {
-    if len(p.Queue) == 0 {
-        return fmt.Errorf("queue is empty")
-    }
-
-    // move back one track
-    p.CurrentIndex--
-    if p.CurrentIndex < 0 {
-        p.CurrentIndex = 0 // or wrap around: len(p.Queue)-1
-    }
-
-    track := p.Queue[p.CurrentIndex]
-    return p.Play(track.Id, track.Uri, track.Title, track.Artist, track.Duration)
-}
-
-func updateQueueList(player *Player, queueList *tview.List, starredItems map[string]struct{}) {
+func updateQueueList(player *Player, queueList *tview.List, starredItems map[string]struct{}, ratings map[string]int) {
 	queueList.Clear()
-	for _, queueItem := range player.Queue {
-		queueList.AddItem(queueListTextFormat(queueItem, starredItems), "", 0, nil)
+	for _, queueItem := range player.Queue() {
+		queueList.AddItem(queueListTextFormat(queueItem, starredItems, ratings), "", 0, nil)
 	}
 }
 
@@ -1017,7 +1805,7 @@ func (ui *Ui) skipToNextPlaylist() {
 	ui.handlePlaylistSelected(playlist)
 
 	// clear the queue first
-	ui.player.Queue = []QueueItem{}
+	ui.player.ReplaceQueue(nil)
 
 	// enqueue songs from the new playlist directly, avoiding reliance on GetCurrentItem
 	for _, entity := range playlist.Entries {
@@ -1025,102 +1813,84 @@ func (ui *Ui) skipToNextPlaylist() {
 	}
 
 	// refresh queue UI and switch to it
-	updateQueueList(ui.player, ui.queueList, ui.starIdList)
+	updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
 	ui.pages.SwitchToPage("queue")
 	ui.currentPage.SetText("Queue")
 
 	ui.connection.Logger.Printf("Skipped to playlist: %s", playlist.Name)
 }
 
-func (ui *Ui) handleMpvEvents() {
-	ui.player.Instance.ObserveProperty(0, "time-pos", mpv.FORMAT_DOUBLE)
-	ui.player.Instance.ObserveProperty(0, "duration", mpv.FORMAT_DOUBLE)
-	ui.player.Instance.ObserveProperty(0, "volume", mpv.FORMAT_INT64)
-	for {
-		e := <-ui.player.EventChannel
-		if e == nil {
-			break
-			// we don't want to update anything if we're in the process of replacing the current track
-		} else if e.Event_Id == mpv.EVENT_END_FILE && !ui.player.ReplaceInProgress {
-			ui.startStopStatus.SetText("[::b]stmp: [red]stopped")
-			// TODO it's gross that this is here, need better event handling
-			if len(ui.player.Queue) > 0 {
-				ui.player.Queue = ui.player.Queue[1:]
-			}
-			updateQueueList(ui.player, ui.queueList, ui.starIdList)
-			err := ui.player.PlayNextTrack()
-			if err != nil {
-				ui.connection.Logger.Printf("handleMoveEvents: PlayNextTrack -- %s", err.Error())
-			}
-		} else if e.Event_Id == mpv.EVENT_START_FILE {
-			ui.player.ReplaceInProgress = false
-			updateQueueList(ui.player, ui.queueList, ui.starIdList)
-
-			if len(ui.player.Queue) > 0 {
-				currentSong := ui.player.Queue[0]
+// handlePlayerEvents keeps the UI in step with the player. It runs on its own
+// goroutine, anything touching the UI has to go through QueueUpdateDraw.
+func (ui *Ui) handlePlayerEvents() {
+	events, _ := ui.player.Subscribe()
+	var position, duration float64
+	volume := int64(100)
+	for event := range events {
+		event := event
+		switch event.Type {
+		case EventQueueChanged:
+			ui.app.QueueUpdateDraw(func() {
+				updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
+			})
+		case EventTrackEnded:
+			ui.app.QueueUpdateDraw(func() {
+				ui.startStopStatus.SetText("[::b]stmp: [red]stopped")
+				if event.Failed {
+					ui.noteStreamFailure(event.Track)
+				}
+			})
+		case EventTrackStarted:
+			ui.app.QueueUpdateDraw(func() {
+				if downloads := ui.connection.Downloads; downloads != nil && event.Track != nil {
+					downloads.Touch(event.Track.Id)
+				}
+				updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
+				ui.updateNowPlaying()
+				ui.loadLyrics()
+				if event.Track != nil {
+					ui.startStopStatus.SetText("[::b]stmp: [green]playing " + event.Track.Title)
+				}
+			})
+		case EventPaused:
+			ui.app.QueueUpdateDraw(func() {
+				ui.startStopStatus.SetText("[::b]stmp: [yellow]paused")
+			})
+		case EventResumed:
+			ui.app.QueueUpdateDraw(func() {
 				if track := ui.player.CurrentTrack(); track != nil {
-            		ui.startStopStatus.SetText("[::b]stmp: [green]playing " + track.Title)
-        		} else {
-            		ui.startStopStatus.SetText("[::b]stmp: [green]playing " + currentSong.Title)
-       			 }
-				
-
-				if ui.connection.Scrobble {
-					// scrobble "now playing" event
-					ui.connection.ScrobbleSubmission(currentSong.Id, false)
-
-					// scrobble "submission" after song has been playing a bit
-					// see: https://www.last.fm/api/scrobbling
-					// A track should only be scrobbled when the following conditions have been met:
-					// The track must be longer than 30 seconds. And the track has been played for
-					// at least half its duration, or for 4 minutes (whichever occurs earlier.)
-					if currentSong.Duration > 30 {
-						scrobbleDelay := currentSong.Duration / 2
-						if scrobbleDelay > 240 {
-							scrobbleDelay = 240
-						}
-						scrobbleDuration := time.Duration(scrobbleDelay) * time.Second
-
-						ui.scrobbleTimer.Reset(scrobbleDuration)
-						ui.connection.Logger.Printf("scrobbler: timer started, %v", scrobbleDuration)
-					} else {
-						ui.connection.Logger.Printf("scrobbler: track too short")
-					}
+					ui.startStopStatus.SetText("[::b]stmp: [green]playing " + track.Title)
 				}
-			}
-		} else if e.Event_Id == mpv.EVENT_IDLE || e.Event_Id == mpv.EVENT_NONE {
-			continue
-		}
-
-		position, err := ui.player.Instance.GetProperty("time-pos", mpv.FORMAT_DOUBLE)
-		if err != nil {
-			ui.connection.Logger.Printf("handleMoveEvents (%s): GetProperty %s -- %s", e.Event_Id.String(), "time-pos", err.Error())
-		}
-		// TODO only update these as needed
-		duration, err := ui.player.Instance.GetProperty("duration", mpv.FORMAT_DOUBLE)
-		if err != nil {
-			ui.connection.Logger.Printf("handleMoveEvents (%s): GetProperty %s -- %s", e.Event_Id.String(), "duration", err.Error())
-		}
-		volume, err := ui.player.Instance.GetProperty("volume", mpv.FORMAT_INT64)
-		if err != nil {
-			ui.connection.Logger.Printf("handleMoveEvents (%s): GetProperty %s -- %s", e.Event_Id.String(), "volume", err.Error())
-		}
-
-		if position == nil {
-			position = 0.0
-		}
-
-		if duration == nil {
-			duration = 0.0
-		}
-
-		if volume == nil {
-			volume = 0
+			})
+		case EventVolumeChanged:
+			volume = event.Volume
+			status := formatPlayerStatus(volume, position, duration)
+			ui.app.QueueUpdateDraw(func() {
+				ui.playerStatus.SetText(status)
+			})
+		case EventPositionChanged, EventSeeked:
+			position, duration = event.Position, event.Duration
+			status := formatPlayerStatus(volume, position, duration)
+			ui.app.QueueUpdateDraw(func() {
+				ui.playerStatus.SetText(status)
+				ui.updateNowPlayingProgress(event.Position, event.Duration)
+				ui.updateLyricsPosition(event.Position)
+			})
 		}
+	}
+}
 
-		ui.playerStatus.SetText(formatPlayerStatus(volume.(int64), position.(float64), duration.(float64)))
-		ui.app.Draw()
+// noteStreamFailure warns, once, that server.proxyBasicAuth may be why track
+// couldn't be played: subsonic servers themselves don't take basic auth on
+// streams, only a proxy in front of them can
+func (ui *Ui) noteStreamFailure(track *QueueItem) {
+	if ui.proxyBasicAuthWarned || track == nil || !ui.connection.ProxyBasicAuth ||
+		!strings.HasPrefix(track.Uri, ui.connection.Host) {
+		return
 	}
+	ui.proxyBasicAuthWarned = true
+	ui.connection.Logger.PrintError(errors.New("server.proxyBasicAuth only works behind a reverse proxy that checks basic auth, turn it off otherwise"),
+		"noteStreamFailure: %s couldn't be streamed", track.Title)
 }
 
 func makeModal(p tview.Primitive, width, height int) tview.Primitive {
@@ -1188,13 +1958,6 @@ func (e SubsonicEntity) getSongTitle() string {
 	return e.Path[lastSlash+1 : len(e.Path)]
 }
 
-func (p *Player) CurrentTrack() *QueueItem {
-    if len(p.Queue) == 0 || p.CurrentIndex < 0 || p.CurrentIndex >= len(p.Queue) {
-        return nil
-    }
-    return &p.Queue[p.CurrentIndex]
-}
-
 func keyName(event *tcell.EventKey) string {
 	if (event.Key() == tcell.KeyRune) {
 		return string(event.Rune())
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PlayRecord is one play in the listening history
+type PlayRecord struct {
+	Id       string    `json:"id"`
+	Title    string    `json:"title"`
+	Artist   string    `json:"artist"`
+	Album    string    `json:"album"`
+	Started  time.Time `json:"started"`
+	Ended    time.Time `json:"ended"`
+	Duration int       `json:"duration"` // of the song, in seconds
+	Listened float64   `json:"listened"` // seconds actually played, see playTracker
+	Percent  float64   `json:"percent"`  // of the song listened to
+	// the song was stopped or replaced before its end
+	Skipped bool `json:"skipped"`
+}
+
+// History is the listening history: every play, oldest first, kept in a file
+// with one JSON record per line that is only ever appended to
+type History struct {
+	path   string // "" to keep nothing on disk
+	logger Logger
+
+	lock  sync.Mutex
+	plays []PlayRecord
+	// called, without lock held, when a play is added
+	onChange func()
+}
+
+// historyPath is where the history is kept unless history.file says
+// otherwise: $XDG_DATA_HOME/stmp/history.jsonl. It isn't a cache, so it
+// doesn't go in the cache directory.
+func historyPath() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "stmp", "history.jsonl")
+}
+
+// OpenHistory reads the history in path. Lines that can't be read, say the
+// last one after a crash, are skipped.
+func OpenHistory(path string, logger Logger) *History {
+	h := &History{path: path, logger: logger}
+	if path == "" {
+		return h
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Printf("OpenHistory: %s", err.Error())
+		}
+		return h
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	skipped := 0
+	for scanner.Scan() {
+		var play PlayRecord
+		if err := json.Unmarshal(scanner.Bytes(), &play); err != nil {
+			skipped++
+			continue
+		}
+		h.plays = append(h.plays, play)
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Printf("OpenHistory: %s", err.Error())
+	}
+	if skipped > 0 {
+		logger.Printf("OpenHistory: skipped %d unreadable plays in %s", skipped, path)
+	}
+	return h
+}
+
+func (h *History) SetOnChange(onChange func()) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.onChange = onChange
+}
+
+// Add appends play to the history
+func (h *History) Add(play PlayRecord) {
+	h.lock.Lock()
+	h.plays = append(h.plays, play)
+	if err := h.append(play); err != nil {
+		h.logger.Printf("History: saving %s -- %s", h.path, err.Error())
+	}
+	onChange := h.onChange
+	h.lock.Unlock()
+
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// append writes play to the end of the file. It expects lock to be held.
+func (h *History) append(play PlayRecord) error {
+	if h.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(play)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Recent returns the last n plays, newest first
+func (h *History) Recent(n int) []PlayRecord {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if n > len(h.plays) {
+		n = len(h.plays)
+	}
+	recent := make([]PlayRecord, n)
+	for i := range recent {
+		recent[i] = h.plays[len(h.plays)-1-i]
+	}
+	return recent
+}
+
+// HistoryCount is an artist, album or song with how often and how long it was
+// played
+type HistoryCount struct {
+	Name     string
+	Plays    int
+	Listened float64 // in seconds
+}
+
+// HistoryStats sums up the plays in a period
+type HistoryStats struct {
+	Plays    int
+	Listened float64 // in seconds
+	Artists  []HistoryCount
+	Albums   []HistoryCount
+	Songs    []HistoryCount
+}
+
+// Stats sums up the plays started since since, keeping the top n artists,
+// albums and songs. Skipped plays count towards the time listened, but not
+// as plays.
+func (h *History) Stats(since time.Time, n int) HistoryStats {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var stats HistoryStats
+	artists := make(map[string]*HistoryCount)
+	albums := make(map[string]*HistoryCount)
+	songs := make(map[string]*HistoryCount)
+	count := func(counts map[string]*HistoryCount, key, name string, play PlayRecord) {
+		if name == "" {
+			return
+		}
+		c, ok := counts[key]
+		if !ok {
+			c = &HistoryCount{Name: name}
+			counts[key] = c
+		}
+		if !play.Skipped {
+			c.Plays++
+		}
+		c.Listened += play.Listened
+	}
+
+	for _, play := range h.plays {
+		if play.Started.Before(since) {
+			continue
+		}
+		if !play.Skipped {
+			stats.Plays++
+		}
+		stats.Listened += play.Listened
+		count(artists, play.Artist, play.Artist, play)
+		if play.Album != "" {
+			count(albums, play.Artist+"\x00"+play.Album, play.Album+" - "+play.Artist, play)
+		}
+		count(songs, play.Id, play.Title+" - "+play.Artist, play)
+	}
+
+	stats.Artists = topCounts(artists, n)
+	stats.Albums = topCounts(albums, n)
+	stats.Songs = topCounts(songs, n)
+	return stats
+}
+
+// topCounts returns the n most played of counts, by plays and then by time
+func topCounts(counts map[string]*HistoryCount, n int) []HistoryCount {
+	top := make([]HistoryCount, 0, len(counts))
+	for _, c := range counts {
+		top = append(top, *c)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Plays != top[j].Plays {
+			return top[i].Plays > top[j].Plays
+		}
+		if top[i].Listened != top[j].Listened {
+			return top[i].Listened > top[j].Listened
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// runHistory adds every play to history as it ends, until the player shuts
+// down or ctx is done
+func runHistory(ctx context.Context, player *Player, history *History) {
+	events, unsubscribe := player.Subscribe()
+	defer unsubscribe()
+
+	var tracker *playTracker
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			switch event.Type {
+			case EventTrackStarted:
+				tracker = nil
+				if event.Track != nil {
+					tracker = newPlayTracker(*event.Track, time.Now())
+				}
+
+			case EventTrackEnded:
+				if tracker != nil && event.Track != nil && event.Track.Id == tracker.track.Id {
+					// songs that never really played aren't plays
+					if tracker.listened >= 1 {
+						history.Add(tracker.record(time.Now(), !event.Finished))
+					}
+				}
+				tracker = nil
+
+			case EventSeeked:
+				if tracker != nil {
+					tracker.seeked(event.Position, time.Now())
+				}
+
+			case EventPositionChanged:
+				if tracker != nil {
+					tracker.progressed(event.Position, event.Duration, time.Now())
+				}
+			}
+		}
+	}
+}
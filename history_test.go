@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatsPeriods(t *testing.T) {
+	at := func(s string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		period string
+		now    string
+		start  string
+	}{
+		{"This week", "2025-03-12 15:30", "2025-03-10 00:00"}, // a wednesday
+		{"This week", "2025-03-10 00:00", "2025-03-10 00:00"}, // monday's first minute
+		{"This week", "2025-03-16 23:59", "2025-03-10 00:00"}, // sunday's last
+		{"This week", "2025-03-02 12:00", "2025-02-24 00:00"}, // from the month before
+		{"This week", "2025-01-01 12:00", "2024-12-30 00:00"}, // from the year before
+		{"This month", "2025-03-01 00:00", "2025-03-01 00:00"},
+		{"This month", "2025-03-31 23:59", "2025-03-01 00:00"},
+		{"This month", "2024-02-29 12:00", "2024-02-01 00:00"},
+		{"This year", "2025-12-31 23:59", "2025-01-01 00:00"},
+		{"This year", "2025-01-01 00:00", "2025-01-01 00:00"},
+	}
+	for _, test := range tests {
+		t.Run(test.period+" "+test.now, func(t *testing.T) {
+			for _, period := range statsPeriods {
+				if period.name != test.period {
+					continue
+				}
+				if start := period.start(at(test.now)); !start.Equal(at(test.start)) {
+					t.Errorf("started %s, want %s", start.Format("Mon 2006-01-02 15:04"), test.start)
+				}
+				return
+			}
+			t.Fatalf("no period %q", test.period)
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	since := time.Date(2025, 3, 10, 0, 0, 0, 0, time.Local)
+	play := func(id, artist string, started time.Duration, listened float64, skipped bool) PlayRecord {
+		return PlayRecord{
+			Id:       id,
+			Title:    "song " + id,
+			Artist:   artist,
+			Album:    "album " + artist,
+			Started:  since.Add(started),
+			Listened: listened,
+			Skipped:  skipped,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		plays    []PlayRecord
+		total    int
+		listened float64
+		artists  []HistoryCount
+	}{
+		{
+			name:    "nothing played",
+			artists: []HistoryCount{},
+		},
+		{
+			name:     "the first second counts",
+			plays:    []PlayRecord{play("1", "a", 0, 100, false)},
+			total:    1,
+			listened: 100,
+			artists:  []HistoryCount{{"a", 1, 100}},
+		},
+		{
+			name:    "the second before doesn't",
+			plays:   []PlayRecord{play("1", "a", -time.Second, 100, false)},
+			artists: []HistoryCount{},
+		},
+		{
+			name:     "a play started before but ended after doesn't",
+			plays:    []PlayRecord{play("1", "a", -time.Minute, 100, false), play("2", "b", time.Hour, 50, false)},
+			total:    1,
+			listened: 50,
+			artists:  []HistoryCount{{"b", 1, 50}},
+		},
+		{
+			name:     "skipped plays count as time only",
+			plays:    []PlayRecord{play("1", "a", time.Hour, 30, true), play("2", "a", 2*time.Hour, 100, false)},
+			total:    1,
+			listened: 130,
+			artists:  []HistoryCount{{"a", 1, 130}},
+		},
+		{
+			name: "most plays first, then most time",
+			plays: []PlayRecord{
+				play("1", "a", time.Hour, 100, false),
+				play("2", "b", 2*time.Hour, 100, false),
+				play("3", "b", 3*time.Hour, 100, false),
+				play("4", "c", 4*time.Hour, 300, false),
+			},
+			total:    4,
+			listened: 600,
+			artists:  []HistoryCount{{"b", 2, 200}, {"c", 1, 300}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &History{logger: testLogger(), plays: test.plays}
+			stats := h.Stats(since, 2)
+			if stats.Plays != test.total || stats.Listened != test.listened {
+				t.Errorf("%d plays, %.0fs listened, want %d, %.0fs", stats.Plays, stats.Listened, test.total, test.listened)
+			}
+			if !reflect.DeepEqual(stats.Artists, test.artists) {
+				t.Errorf("artists %v, want %v", stats.Artists, test.artists)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// how many plays the history page shows, and how many of each the stats page
+const (
+	historyPageSize = 500
+	statsTopSize    = 10
+)
+
+// statsPeriods are the periods the stats page sums up, switched between with
+// left and right
+var statsPeriods = []struct {
+	name  string
+	start func(now time.Time) time.Time
+}{
+	{"This week", func(now time.Time) time.Time {
+		y, m, d := now.Date()
+		// weeks start on monday
+		return time.Date(y, m, d-(int(now.Weekday())+6)%7, 0, 0, 0, 0, now.Location())
+	}},
+	{"This month", func(now time.Time) time.Time {
+		y, m, _ := now.Date()
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	}},
+	{"This year", func(now time.Time) time.Time {
+		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+	}},
+	{"All time", func(now time.Time) time.Time {
+		return time.Time{}
+	}},
+}
+
+// createHistoryPage lists recent plays, newest first
+func (ui *Ui) createHistoryPage(titleFlex *tview.Flex) *tview.Flex {
+	ui.historyList = tview.NewList().ShowSecondaryText(false)
+	ui.historyList.SetBorder(true).SetTitle("History")
+
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(titleFlex, 1, 0, false).
+		AddItem(ui.historyList, 0, 1, true)
+}
+
+// createStatsPage sums up the history: plays, time listened and the top
+// artists, albums and songs of a period
+func (ui *Ui) createStatsPage(titleFlex *tview.Flex) *tview.Flex {
+	ui.statsView = tview.NewTextView().SetDynamicColors(true)
+	ui.statsView.SetBorder(true).SetTitle("Stats")
+
+	ui.statsView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch keyName(event) {
+		case keybind("left"):
+			ui.statsPeriod = (ui.statsPeriod + len(statsPeriods) - 1) % len(statsPeriods)
+			ui.updateStats()
+			return nil
+		case keybind("right"):
+			ui.statsPeriod = (ui.statsPeriod + 1) % len(statsPeriods)
+			ui.updateStats()
+			return nil
+		}
+		return event
+	})
+
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(titleFlex, 1, 0, false).
+		AddItem(ui.statsView, 0, 1, true)
+}
+
+// followHistory keeps the history and stats pages up to date
+func (ui *Ui) followHistory() {
+	ui.history.SetOnChange(func() {
+		ui.app.QueueUpdateDraw(func() {
+			ui.updateHistoryList()
+			ui.updateStats()
+		})
+	})
+	ui.updateHistoryList()
+	ui.updateStats()
+}
+
+func (ui *Ui) updateHistoryList() {
+	ui.historyList.Clear()
+	plays := ui.history.Recent(historyPageSize)
+	if len(plays) == 0 {
+		ui.historyList.AddItem("[::d]nothing played yet", "", 0, nil)
+		return
+	}
+	for _, play := range plays {
+		ui.historyList.AddItem(historyListTextFormat(play), "", 0, nil)
+	}
+}
+
+func historyListTextFormat(play PlayRecord) string {
+	text := fmt.Sprintf("[::d]%s[::-]  %s - %s [::d]%.0f%%",
+		play.Started.Format("Mon 02 Jan 15:04"), tview.Escape(play.Title), tview.Escape(play.Artist), play.Percent)
+	if play.Skipped {
+		text += " [yellow]skipped"
+	}
+	return text
+}
+
+func (ui *Ui) updateStats() {
+	period := statsPeriods[ui.statsPeriod]
+	stats := ui.history.Stats(period.start(time.Now()), statsTopSize)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "[::b]%s[::-] [::d](%s and %s for other periods)[::-]\n\n",
+		period.name, keybind("left"), keybind("right"))
+	fmt.Fprintf(&text, "%d plays, %s listened\n", stats.Plays, formatListeningTime(stats.Listened))
+
+	sections := []struct {
+		title  string
+		counts []HistoryCount
+	}{
+		{"Top artists", stats.Artists},
+		{"Top albums", stats.Albums},
+		{"Top songs", stats.Songs},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(&text, "\n[::b]%s[::-]\n", section.title)
+		if len(section.counts) == 0 {
+			text.WriteString("  [::d]none[::-]\n")
+		}
+		for i, count := range section.counts {
+			fmt.Fprintf(&text, "%3d. %s [::d]%d plays, %s[::-]\n",
+				i+1, tview.Escape(count.Name), count.Plays, formatListeningTime(count.Listened))
+		}
+	}
+
+	ui.statsView.SetText(text.String())
+	ui.statsView.ScrollToBeginning()
+}
+
+// formatListeningTime renders seconds as hours and minutes
+func formatListeningTime(seconds float64) string {
+	minutes := int(seconds) / 60
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %02dm", minutes/60, minutes%60)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// player events a hook can be set for, keyed by their name in the [hooks]
+// config section. Position updates are left out, they come several times a
+// second.
+var hookEvents = map[PlayerEventType]string{
+	EventTrackStarted:  "trackStarted",
+	EventTrackEnded:    "trackEnded",
+	EventPaused:        "paused",
+	EventResumed:       "resumed",
+	EventSeeked:        "seeked",
+	EventVolumeChanged: "volumeChanged",
+	EventQueueChanged:  "queueChanged",
+}
+
+// runHooks runs the shell commands configured for player events, e.g. to send
+// a notification when a track starts. Details of the event are passed in
+// STMP_* environment variables. It returns when the player shuts down.
+func runHooks(player *Player, hooks map[string]string, logger Logger) {
+	events, _ := player.Subscribe()
+	for event := range events {
+		name, ok := hookEvents[event.Type]
+		if !ok || strings.TrimSpace(hooks[strings.ToLower(name)]) == "" {
+			continue
+		}
+
+		cmd := exec.Command("sh", "-c", hooks[strings.ToLower(name)])
+		cmd.Env = append(os.Environ(), hookEnvironment(name, event)...)
+		if err := cmd.Start(); err != nil {
+			logger.Printf("runHooks: %s -- %s", name, err.Error())
+			continue
+		}
+		// don't hold up the next event while the hook runs
+		go func() {
+			if err := cmd.Wait(); err != nil {
+				logger.Printf("runHooks: %s -- %s", name, err.Error())
+			}
+		}()
+	}
+}
+
+func hookEnvironment(name string, event PlayerEvent) []string {
+	env := []string{"STMP_EVENT=" + name}
+	if track := event.Track; track != nil {
+		env = append(env,
+			"STMP_ID="+track.Id,
+			"STMP_TITLE="+track.Title,
+			"STMP_ARTIST="+track.Artist,
+			"STMP_ALBUM="+track.Album,
+			"STMP_DURATION="+strconv.Itoa(track.Duration))
+	}
+	switch event.Type {
+	case EventSeeked:
+		env = append(env, "STMP_POSITION="+strconv.FormatFloat(event.Position, 'f', 0, 64))
+	case EventVolumeChanged:
+		env = append(env, "STMP_VOLUME="+strconv.FormatInt(event.Volume, 10))
+	}
+	return env
+}
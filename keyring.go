@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// The keyring is reached through the freedesktop Secret Service API, provided
+// by gnome-keyring, KWallet and KeePassXC among others. See
+// https://specifications.freedesktop.org/secret-service/
+const (
+	secretServiceName       = "org.freedesktop.secrets"
+	secretServicePath       = "/org/freedesktop/secrets"
+	secretServiceInterface  = "org.freedesktop.Secret.Service"
+	secretDefaultCollection = "/org/freedesktop/secrets/aliases/default"
+)
+
+// ErrNoSecret is returned by keyringPassword when the keyring has no password
+// for the account
+var ErrNoSecret = errors.New("no password in the keyring")
+
+// secret is the Secret struct of the Secret Service API
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// keyring is a session with the Secret Service
+type keyring struct {
+	conn    *dbus.Conn
+	service dbus.BusObject
+	session dbus.ObjectPath
+}
+
+func openKeyring() (*keyring, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	service := conn.Object(secretServiceName, secretServicePath)
+	// secrets are sent unencrypted over the session bus, which only the user
+	// can connect to
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err = service.Call(secretServiceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+	if err != nil {
+		return nil, fmt.Errorf("opening a secret service session: %w", err)
+	}
+
+	return &keyring{conn: conn, service: service, session: session}, nil
+}
+
+func (k *keyring) Close() {
+	k.conn.Object(secretServiceName, k.session).Call("org.freedesktop.Secret.Session.Close", 0)
+}
+
+// secretAttributes identify stmp's password for a user on a server
+func secretAttributes(host, username string) map[string]string {
+	return map[string]string{
+		"application": "stmp",
+		"server":      host,
+		"username":    username,
+	}
+}
+
+// keyringPassword looks up the password for username on host, unlocking the
+// keyring if need be, which may prompt the user
+func keyringPassword(host, username string) (string, error) {
+	k, err := openKeyring()
+	if err != nil {
+		return "", err
+	}
+	defer k.Close()
+
+	var unlocked, locked []dbus.ObjectPath
+	err = k.service.Call(secretServiceInterface+".SearchItems", 0, secretAttributes(host, username)).Store(&unlocked, &locked)
+	if err != nil {
+		return "", err
+	}
+
+	if len(unlocked) == 0 && len(locked) > 0 {
+		var prompt dbus.ObjectPath
+		err = k.service.Call(secretServiceInterface+".Unlock", 0, locked).Store(&unlocked, &prompt)
+		if err != nil {
+			return "", err
+		}
+		if prompt != "/" {
+			if err := k.prompt(prompt); err != nil {
+				return "", err
+			}
+			unlocked = locked
+		}
+	}
+	if len(unlocked) == 0 {
+		return "", ErrNoSecret
+	}
+
+	var secrets map[dbus.ObjectPath]secret
+	err = k.service.Call(secretServiceInterface+".GetSecrets", 0, unlocked[:1], k.session).Store(&secrets)
+	if err != nil {
+		return "", err
+	}
+	s, ok := secrets[unlocked[0]]
+	if !ok {
+		return "", ErrNoSecret
+	}
+	return string(s.Value), nil
+}
+
+// storeKeyringPassword saves the password for username on host in the default
+// collection, replacing any saved before
+func storeKeyringPassword(host, username, password string) error {
+	k, err := openKeyring()
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	properties := map[string]dbus.Variant{
+		"org.freedesktop.Secret.Item.Label":      dbus.MakeVariant(fmt.Sprintf("stmp password for %s on %s", username, host)),
+		"org.freedesktop.Secret.Item.Attributes": dbus.MakeVariant(secretAttributes(host, username)),
+	}
+	value := secret{
+		Session:     k.session,
+		Parameters:  []byte{},
+		Value:       []byte(password),
+		ContentType: "text/plain",
+	}
+
+	collection := k.conn.Object(secretServiceName, secretDefaultCollection)
+	var item, prompt dbus.ObjectPath
+	err = collection.Call("org.freedesktop.Secret.Collection.CreateItem", 0, properties, value, true).Store(&item, &prompt)
+	if err != nil {
+		return err
+	}
+	if prompt != "/" {
+		return k.prompt(prompt)
+	}
+	return nil
+}
+
+// prompt shows a Secret Service prompt, e.g. for the keyring's password, and
+// waits for the user to deal with it
+func (k *keyring) prompt(path dbus.ObjectPath) error {
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface("org.freedesktop.Secret.Prompt"),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := k.conn.AddMatchSignal(match...); err != nil {
+		return err
+	}
+	defer k.conn.RemoveMatchSignal(match...)
+
+	signals := make(chan *dbus.Signal, 1)
+	k.conn.Signal(signals)
+	defer k.conn.RemoveSignal(signals)
+
+	err := k.conn.Object(secretServiceName, path).Call("org.freedesktop.Secret.Prompt.Prompt", 0, "").Err
+	if err != nil {
+		return err
+	}
+
+	for signal := range signals {
+		if signal.Path != path || signal.Name != "org.freedesktop.Secret.Prompt.Completed" {
+			continue
+		}
+		if dismissed, ok := signal.Body[0].(bool); ok && dismissed {
+			return errors.New("the keyring prompt was dismissed")
+		}
+		return nil
+	}
+	return errors.New("lost the connection to the keyring")
+}
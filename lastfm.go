@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultLastFMUrl = "https://ws.audioscrobbler.com/2.0/"
+	lastFMAuthUrl    = "https://www.last.fm/api/auth/"
+)
+
+// LastFM scrobbles to Last.fm as the user a session key was issued for, see
+// https://www.last.fm/api/scrobbling. Authenticate gets a session key.
+type LastFM struct {
+	Url        string // the API root, defaultLastFMUrl if empty
+	ApiKey     string // of an API account, https://www.last.fm/api/account/create
+	Secret     string // shared secret of the API account
+	SessionKey string
+	Client     *http.Client // http.DefaultClient if nil
+}
+
+// LastFMError is an error Last.fm answered with, see
+// https://www.last.fm/api/errorcodes
+type LastFMError struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *LastFMError) Error() string {
+	return fmt.Sprintf("Last.fm error %d: %s", e.Code, e.Message)
+}
+
+// Temporary reports whether Last.fm asked to be tried again later: it is
+// offline, temporarily unavailable or rate limiting
+func (e *LastFMError) Temporary() bool {
+	return e.Code == 11 || e.Code == 16 || e.Code == 29
+}
+
+// Is has errors about the API account, the signature or the session, which
+// expires when the user revokes it, match ErrAuthFailed
+func (e *LastFMError) Is(target error) bool {
+	switch e.Code {
+	case 4, 9, 10, 13, 14, 15, 26:
+		return target == ErrAuthFailed
+	}
+	return false
+}
+
+// Rejected reports whether Last.fm found fault with the track itself
+func (e *LastFMError) Rejected() bool {
+	return e.Code == 6 || e.Code == 7
+}
+
+func (l *LastFM) NowPlaying(ctx context.Context, track QueueItem) error {
+	params := lastFMTrackParams(track.Title, track.Artist, track.Album, track.Duration)
+	_, err := l.call(ctx, "track.updateNowPlaying", params)
+	return err
+}
+
+func (l *LastFM) Submit(ctx context.Context, scrobble PendingScrobble) error {
+	params := lastFMTrackParams(scrobble.Title, scrobble.Artist, scrobble.Album, scrobble.Duration)
+	params.Set("timestamp", strconv.FormatInt(scrobble.Time.Unix(), 10))
+	_, err := l.call(ctx, "track.scrobble", params)
+	return err
+}
+
+func lastFMTrackParams(title, artist, album string, duration int) url.Values {
+	params := url.Values{}
+	params.Set("track", title)
+	params.Set("artist", artist)
+	if album != "" {
+		params.Set("album", album)
+	}
+	if duration > 0 {
+		params.Set("duration", strconv.Itoa(duration))
+	}
+	return params
+}
+
+// Authenticate gets a session key for the user, who has to allow stmp access
+// on the Last.fm website: waitForUser is given the page to send them to, and
+// returns once they're done
+func (l *LastFM) Authenticate(ctx context.Context, waitForUser func(authUrl string) error) (string, error) {
+	body, err := l.call(ctx, "auth.getToken", url.Values{})
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("auth.getToken: %w", err)
+	}
+
+	authUrl := lastFMAuthUrl + "?" + url.Values{"api_key": {l.ApiKey}, "token": {token.Token}}.Encode()
+	if err := waitForUser(authUrl); err != nil {
+		return "", err
+	}
+
+	body, err = l.call(ctx, "auth.getSession", url.Values{"token": {token.Token}})
+	if err != nil {
+		return "", err
+	}
+	var session struct {
+		Session struct {
+			Key string `json:"key"`
+		} `json:"session"`
+	}
+	if err := json.Unmarshal(body, &session); err != nil {
+		return "", fmt.Errorf("auth.getSession: %w", err)
+	}
+	return session.Session.Key, nil
+}
+
+// call POSTs a signed request for method and returns the body of the answer
+func (l *LastFM) call(ctx context.Context, method string, params url.Values) ([]byte, error) {
+	params.Set("method", method)
+	params.Set("api_key", l.ApiKey)
+	if l.SessionKey != "" {
+		params.Set("sk", l.SessionKey)
+	}
+	params.Set("api_sig", lastFMSignature(params, l.Secret))
+	params.Set("format", "json")
+
+	root := l.Url
+	if root == "" {
+		root = defaultLastFMUrl
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, root, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// errors come as JSON, whatever the status
+	var failure LastFMError
+	if json.Unmarshal(body, &failure) == nil && failure.Code != 0 {
+		return nil, &failure
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+	return body, nil
+}
+
+// lastFMSignature signs params: the md5 of every name and value, sorted by
+// name, followed by the secret
+func lastFMSignature(params url.Values, secret string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != "format" && name != "callback" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var signed strings.Builder
+	for _, name := range names {
+		signed.WriteString(name)
+		signed.WriteString(params.Get(name))
+	}
+	signed.WriteString(secret)
+	return fmt.Sprintf("%x", md5.Sum([]byte(signed.String())))
+}
+
+// loginLastFM is --lastfm-login: it walks the user through authorizing stmp
+// and prints the session key to put in the config
+func loginLastFM() {
+	lastFM := &LastFM{
+		Url:    viper.GetString("scrobble.lastfm.url"),
+		ApiKey: viper.GetString("scrobble.lastfm.apiKey"),
+		Secret: viper.GetString("scrobble.lastfm.secret"),
+	}
+	if lastFM.ApiKey == "" || lastFM.Secret == "" {
+		fmt.Println("Set scrobble.lastfm.apiKey and scrobble.lastfm.secret first, see https://www.last.fm/api/account/create")
+		os.Exit(1)
+	}
+
+	sessionKey, err := lastFM.Authenticate(context.Background(), func(authUrl string) error {
+		fmt.Printf("Open %s, allow stmp access, then press enter.\n", authUrl)
+		_, err := stdin.ReadString('\n')
+		return err
+	})
+	if err != nil {
+		fmt.Printf("Unable to log in to Last.fm: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Add this to [scrobble.lastfm] in stmp.toml:\n\nsessionKey = '%s'\n", sessionKey)
+}
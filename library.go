@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Library keeps server responses (indexes, directories, albums, playlists) in
+// memory and, given a directory, on disk, so the next run can start with them
+// instead of waiting for the server.
+type Library struct {
+	dir    string // "" to keep nothing on disk
+	maxAge time.Duration
+
+	lock       sync.Mutex
+	entries    map[string]libraryEntry
+	refreshing map[string]bool
+}
+
+type libraryEntry struct {
+	Fetched  time.Time        `json:"fetched"`
+	Response SubsonicResponse `json:"response"`
+}
+
+// NewLibrary opens the store in dir. Entries older than maxAge are refreshed
+// in the background when used, see cached.
+func NewLibrary(dir string, maxAge time.Duration) *Library {
+	return &Library{
+		dir:        dir,
+		maxAge:     maxAge,
+		entries:    make(map[string]libraryEntry),
+		refreshing: make(map[string]bool),
+	}
+}
+
+// Keys are a kind, optionally followed by a slash and an id: "indexes",
+// "directory/123". Each kind has its own directory on disk.
+func (l *Library) path(key string) string {
+	i := strings.IndexByte(key, '/')
+	if i < 0 {
+		return filepath.Join(l.dir, key+".json")
+	}
+	// ids come from the server and may not make good file names
+	return filepath.Join(l.dir, key[:i], fmt.Sprintf("%x.json", md5.Sum([]byte(key[i+1:]))))
+}
+
+// load returns the entry for key, reading it from disk if need be
+func (l *Library) load(key string) (libraryEntry, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if entry, ok := l.entries[key]; ok {
+		return entry, true
+	}
+	if l.dir == "" {
+		return libraryEntry{}, false
+	}
+
+	data, err := ioutil.ReadFile(l.path(key))
+	if err != nil {
+		return libraryEntry{}, false
+	}
+	var entry libraryEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return libraryEntry{}, false
+	}
+	l.entries[key] = entry
+	return entry, true
+}
+
+// Get returns the stored response for key. It is shared with every other
+// caller, so change a copy.
+func (l *Library) Get(key string) (*SubsonicResponse, bool) {
+	if l == nil {
+		return nil, false
+	}
+	entry, ok := l.load(key)
+	if !ok {
+		return nil, false
+	}
+	return &entry.Response, true
+}
+
+// Has reports whether there is a response for key, without reading it
+func (l *Library) Has(key string) bool {
+	if l == nil {
+		return false
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.entries[key]; ok {
+		return true
+	}
+	if l.dir == "" {
+		return false
+	}
+	_, err := os.Stat(l.path(key))
+	return err == nil
+}
+
+// Put stores response under key
+func (l *Library) Put(key string, response *SubsonicResponse) error {
+	if l == nil {
+		return nil
+	}
+	entry := libraryEntry{Fetched: time.Now(), Response: *response}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries[key] = entry
+	if l.dir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// write and rename, so a crash can't leave half an entry
+	path := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Forget drops key
+func (l *Library) Forget(key string) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.entries, key)
+	if l.dir != "" {
+		os.Remove(l.path(key))
+	}
+}
+
+// ForgetKind drops every entry of a kind, e.g. all directories
+func (l *Library) ForgetKind(kind string) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for key := range l.entries {
+		if strings.HasPrefix(key, kind+"/") {
+			delete(l.entries, key)
+		}
+	}
+	if l.dir != "" {
+		os.RemoveAll(filepath.Join(l.dir, kind))
+	}
+}
+
+// cached returns the stored response for key, or fetches and stores it. A
+// response older than maxAge is returned as is and refreshed in the
+// background, for next time. Like Get's, the response is shared.
+func (l *Library) cached(ctx context.Context, key string, logger Logger, fetch func(ctx context.Context) (*SubsonicResponse, error)) (*SubsonicResponse, error) {
+	if l == nil {
+		return fetch(ctx)
+	}
+
+	entry, ok := l.load(key)
+	if !ok {
+		response, err := fetch(ctx)
+		if err != nil {
+			return response, err
+		}
+		if err := l.Put(key, response); err != nil {
+			logger.Printf("Library: storing %s -- %s", key, err.Error())
+		}
+		return response, nil
+	}
+
+	if l.maxAge > 0 && time.Since(entry.Fetched) > l.maxAge {
+		l.refresh(key, logger, fetch)
+	}
+	return &entry.Response, nil
+}
+
+// refresh fetches key again in the background, unless that's already
+// happening
+func (l *Library) refresh(key string, logger Logger, fetch func(ctx context.Context) (*SubsonicResponse, error)) {
+	l.lock.Lock()
+	if l.refreshing[key] {
+		l.lock.Unlock()
+		return
+	}
+	l.refreshing[key] = true
+	l.lock.Unlock()
+
+	go func() {
+		defer func() {
+			l.lock.Lock()
+			delete(l.refreshing, key)
+			l.lock.Unlock()
+		}()
+
+		// the client's timeout bounds this, nobody is waiting on it
+		response, err := fetch(context.Background())
+		if errors.Is(err, ErrOffline) {
+			// it'll be stale again next time
+			return
+		}
+		if err != nil {
+			logger.Printf("Library: refreshing %s -- %s", key, err.Error())
+			return
+		}
+		if err := l.Put(key, response); err != nil {
+			logger.Printf("Library: storing %s -- %s", key, err.Error())
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLibrary(t *testing.T) {
+	keys := []string{"indexes", "directory/1", "directory/2", "album/1"}
+
+	tests := []struct {
+		name    string
+		onDisk  bool
+		forget  string // a key to Forget
+		kind    string // a kind to ForgetKind
+		reopen  bool   // read back with a new Library on the same dir
+		missing []string
+	}{
+		{name: "in memory"},
+		{name: "on disk", onDisk: true},
+		{name: "read back from disk", onDisk: true, reopen: true},
+		{name: "nothing on disk", reopen: true, missing: keys},
+		{name: "forget a key", forget: "directory/1", missing: []string{"directory/1"}},
+		{name: "forget a key on disk", onDisk: true, reopen: true, forget: "directory/1", missing: []string{"directory/1"}},
+		{name: "forget a kind", kind: "directory", missing: []string{"directory/1", "directory/2"}},
+		{name: "forget a kind on disk", onDisk: true, reopen: true, kind: "directory", missing: []string{"directory/1", "directory/2"}},
+		{name: "forget a kind with no entries", onDisk: true, reopen: true, kind: "playlist"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := ""
+			if test.onDisk {
+				dir = t.TempDir()
+			}
+			library := NewLibrary(dir, 0)
+			for _, key := range keys {
+				response := &SubsonicResponse{Status: "ok"}
+				response.Directory.Id = key
+				if err := library.Put(key, response); err != nil {
+					t.Fatalf("Put %s: %s", key, err)
+				}
+			}
+			if test.forget != "" {
+				library.Forget(test.forget)
+			}
+			if test.kind != "" {
+				library.ForgetKind(test.kind)
+			}
+			if test.reopen {
+				library = NewLibrary(dir, 0)
+			}
+
+			missing := make(map[string]bool)
+			for _, key := range test.missing {
+				missing[key] = true
+			}
+			for _, key := range keys {
+				response, ok := library.Get(key)
+				if ok != !missing[key] || library.Has(key) != ok {
+					t.Errorf("%s: Get %v, Has %v, want %v", key, ok, library.Has(key), !missing[key])
+					continue
+				}
+				if ok && response.Directory.Id != key {
+					t.Errorf("%s: got the response for %s", key, response.Directory.Id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const defaultListenBrainzUrl = "https://api.listenbrainz.org"
+
+// ListenBrainz submits listens with a user token, see
+// https://listenbrainz.readthedocs.io/en/latest/users/api/core.html
+type ListenBrainz struct {
+	Url    string       // the API root, defaultListenBrainzUrl if empty
+	Token  string       // from https://listenbrainz.org/settings/
+	Client *http.Client // http.DefaultClient if nil
+}
+
+type listenBrainzSubmission struct {
+	ListenType string               `json:"listen_type"`
+	Payload    []listenBrainzListen `json:"payload"`
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64             `json:"listened_at,omitempty"`
+	TrackMetadata listenBrainzTrack `json:"track_metadata"`
+}
+
+type listenBrainzTrack struct {
+	ArtistName     string                 `json:"artist_name"`
+	TrackName      string                 `json:"track_name"`
+	ReleaseName    string                 `json:"release_name,omitempty"`
+	AdditionalInfo listenBrainzAdditional `json:"additional_info"`
+}
+
+type listenBrainzAdditional struct {
+	DurationMs       int    `json:"duration_ms,omitempty"`
+	SubmissionClient string `json:"submission_client"`
+}
+
+func listenBrainzTrackOf(title, artist, album string, duration int) listenBrainzTrack {
+	return listenBrainzTrack{
+		ArtistName:  artist,
+		TrackName:   title,
+		ReleaseName: album,
+		AdditionalInfo: listenBrainzAdditional{
+			DurationMs:       duration * 1000,
+			SubmissionClient: "stmp",
+		},
+	}
+}
+
+func (lb *ListenBrainz) NowPlaying(ctx context.Context, track QueueItem) error {
+	return lb.submit(ctx, "playing_now", listenBrainzListen{
+		TrackMetadata: listenBrainzTrackOf(track.Title, track.Artist, track.Album, track.Duration),
+	})
+}
+
+func (lb *ListenBrainz) Submit(ctx context.Context, scrobble PendingScrobble) error {
+	return lb.submit(ctx, "single", listenBrainzListen{
+		ListenedAt:    scrobble.Time.Unix(),
+		TrackMetadata: listenBrainzTrackOf(scrobble.Title, scrobble.Artist, scrobble.Album, scrobble.Duration),
+	})
+}
+
+func (lb *ListenBrainz) submit(ctx context.Context, listenType string, listen listenBrainzListen) error {
+	body, err := json.Marshal(listenBrainzSubmission{
+		ListenType: listenType,
+		Payload:    []listenBrainzListen{listen},
+	})
+	if err != nil {
+		return err
+	}
+
+	root := lb.Url
+	if root == "" {
+		root = defaultListenBrainzUrl
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(root, "/")+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+lb.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := lb.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// errors come with a reason, which beats the bare status
+		status := res.Status
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if data, err := ioutil.ReadAll(res.Body); err == nil && json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+			status += ": " + failure.Error
+		}
+		return &HTTPStatusError{StatusCode: res.StatusCode, Status: status}
+	}
+	return nil
+}
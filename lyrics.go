@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// Lyrics holds the lyrics of the current track. Lines only have start times
+// (in milliseconds, offset already applied) when Synced is set.
+type Lyrics struct {
+	TrackId string
+	Synced  bool
+	Lines   []SubsonicLyricsLine
+}
+
+func (ui *Ui) createLyricsPage(titleFlex *tview.Flex) *tview.Flex {
+	ui.lyricsView = tview.NewTextView().
+		SetDynamicColors(true).
+		SetRegions(true).
+		SetWrap(true).
+		SetWordWrap(true).
+		SetTextAlign(tview.AlignCenter)
+	ui.lyricsView.SetBorder(true).SetTitle("Lyrics")
+
+	ui.showLyrics(nil)
+
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(titleFlex, 1, 0, false).
+		AddItem(ui.lyricsView, 0, 1, true)
+}
+
+// loadLyrics fetches lyrics for the current track in the background. Synced
+// OpenSubsonic lyrics are preferred, then unsynced ones, then the classic
+// getLyrics lookup by artist and title.
+func (ui *Ui) loadLyrics() {
+	track := ui.player.CurrentTrack()
+	if track == nil {
+		ui.showLyrics(nil)
+		return
+	}
+
+	ui.lyricsView.SetText("[::d]loading lyrics…")
+	trackId, artist, title := track.Id, track.Artist, track.Title
+
+	connection := ui.connection
+	go func() {
+		lyrics := ui.fetchLyrics(connection, trackId, artist, title)
+		ui.app.QueueUpdateDraw(func() {
+			if current := ui.player.CurrentTrack(); current != nil && current.Id == trackId {
+				ui.showLyrics(lyrics)
+			}
+		})
+	}()
+}
+
+// fetchLyrics runs off the UI goroutine, so it is handed the connection
+func (ui *Ui) fetchLyrics(connection *SubsonicConnection, trackId string, artist string, title string) *Lyrics {
+	if connection.Supports(ExtensionSongLyrics) {
+		if lyrics := ui.fetchStructuredLyrics(connection, trackId); lyrics != nil {
+			return lyrics
+		}
+	}
+
+	response, err := connection.GetLyrics(ui.ctx, artist, title)
+	if err != nil {
+		connection.Logger.Printf("fetchLyrics: GetLyrics %s - %s -- %s", artist, title, err.Error())
+		return nil
+	}
+	if response.Lyrics.Value == "" {
+		return nil
+	}
+
+	lyrics := &Lyrics{TrackId: trackId}
+	for _, line := range strings.Split(response.Lyrics.Value, "\n") {
+		lyrics.Lines = append(lyrics.Lines, SubsonicLyricsLine{Value: strings.TrimRight(line, "\r")})
+	}
+	return lyrics
+}
+
+// fetchStructuredLyrics uses the OpenSubsonic songLyrics extension, returning
+// nil if there are no lyrics for the track
+func (ui *Ui) fetchStructuredLyrics(connection *SubsonicConnection, trackId string) *Lyrics {
+	response, err := connection.GetLyricsBySongId(ui.ctx, trackId)
+	if err != nil {
+		connection.Logger.Printf("fetchLyrics: GetLyricsBySongId %s -- %s", trackId, err.Error())
+		return nil
+	}
+	if len(response.LyricsList.StructuredLyrics) == 0 {
+		return nil
+	}
+
+	structured := response.LyricsList.StructuredLyrics[0]
+	for _, candidate := range response.LyricsList.StructuredLyrics {
+		if candidate.Synced {
+			structured = candidate
+			break
+		}
+	}
+
+	lyrics := &Lyrics{TrackId: trackId, Synced: structured.Synced}
+	for _, line := range structured.Lines {
+		// a positive offset means the lyrics should show up sooner
+		lyrics.Lines = append(lyrics.Lines, SubsonicLyricsLine{
+			Start: line.Start - structured.Offset,
+			Value: line.Value,
+		})
+	}
+	return lyrics
+}
+
+// showLyrics renders lyrics, each line in its own region so the current one
+// can be highlighted
+func (ui *Ui) showLyrics(lyrics *Lyrics) {
+	ui.lyrics = lyrics
+	ui.lyricsLine = -1
+	ui.lyricsView.Highlight()
+
+	if lyrics == nil || len(lyrics.Lines) == 0 {
+		ui.lyricsView.SetText("[::d]no lyrics")
+		return
+	}
+
+	var text strings.Builder
+	for i, line := range lyrics.Lines {
+		fmt.Fprintf(&text, "[\"%d\"]%s[\"\"]\n", i, tview.Escape(line.Value))
+	}
+	ui.lyricsView.SetText(text.String())
+	ui.lyricsView.ScrollToBeginning()
+}
+
+// updateLyricsPosition highlights the line being sung at position (seconds)
+func (ui *Ui) updateLyricsPosition(position float64) {
+	if ui.lyrics == nil || !ui.lyrics.Synced {
+		return
+	}
+
+	ms := int(position * 1000)
+	line := -1
+	for i, l := range ui.lyrics.Lines {
+		if l.Start > ms {
+			break
+		}
+		line = i
+	}
+
+	if line == ui.lyricsLine {
+		return
+	}
+	ui.lyricsLine = line
+	if line == -1 {
+		ui.lyricsView.Highlight()
+		ui.lyricsView.ScrollToBeginning()
+		return
+	}
+	ui.lyricsView.Highlight(fmt.Sprint(line)).ScrollToHighlight()
+}
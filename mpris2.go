@@ -97,7 +97,7 @@ func RegisterPlayer(p *Player, l Logger) (MprisPlayer, error) {
 		MaximumRate, Rate, MinimumRate (float 0-1, x speed)
 	*/
 	metadata := map[string]interface{}{
-		"mpris:trackid":     "",
+		"mpris:trackid":     mprisNoTrack,
 		"mpris:length":      int64(0),
 		"xesam:album":       "",
 		"xesam:albumArtist": "",
@@ -134,13 +134,14 @@ func RegisterPlayer(p *Player, l Logger) (MprisPlayer, error) {
 				return nil
 			},
 			},
-			"PlaybackStatus": {Value: "", Writable: false, Emit: prop.EmitFalse, Callback: nil},
+			"PlaybackStatus": {Value: "Stopped", Writable: false, Emit: prop.EmitTrue, Callback: nil},
 		},
 	}
 	props, err := prop.Export(conn, "/org/mpris/MediaPlayer2", propSpec)
 	if err != nil {
 		return MprisPlayer{}, err
 	}
+	go mpp.followPlayer(props)
 	n := &introspect.Node{
 		Name: "/org/mpris/MediaPlayer2",
 		Interfaces: []introspect.Interface{
@@ -167,6 +168,68 @@ func RegisterPlayer(p *Player, l Logger) (MprisPlayer, error) {
 	return mpp, nil
 }
 
+// followPlayer keeps the exported properties up to date with the player
+func (mpp MprisPlayer) followPlayer(props *prop.Properties) {
+	const iface = "org.mpris.MediaPlayer2.Player"
+	events, _ := mpp.player.Subscribe()
+	for event := range events {
+		switch event.Type {
+		case EventTrackStarted:
+			if event.Track == nil {
+				continue
+			}
+			props.SetMust(iface, "Metadata", mprisMetadata(event.Track))
+			props.SetMust(iface, "PlaybackStatus", "Playing")
+		case EventTrackEnded:
+			if mpp.player.CurrentTrack() == nil {
+				props.SetMust(iface, "PlaybackStatus", "Stopped")
+			}
+		case EventPaused:
+			props.SetMust(iface, "PlaybackStatus", "Paused")
+		case EventResumed:
+			props.SetMust(iface, "PlaybackStatus", "Playing")
+		case EventVolumeChanged:
+			props.SetMust(iface, "Volume", float64(event.Volume)/100)
+		}
+	}
+}
+
+// mprisNoTrack is the trackid the spec reserves for when nothing is playing
+const mprisNoTrack = dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+
+// mprisTrackId turns a song id into the object path the spec wants for
+// mpris:trackid. Path elements only take [A-Za-z0-9_], so anything else, and
+// _ itself, is written as _ and two hex digits.
+func mprisTrackId(id string) dbus.ObjectPath {
+	if id == "" {
+		return mprisNoTrack
+	}
+	var element strings.Builder
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			element.WriteByte(c)
+		} else {
+			fmt.Fprintf(&element, "_%02x", c)
+		}
+	}
+	return dbus.ObjectPath("/org/stmp/track/" + element.String())
+}
+
+func mprisMetadata(track *QueueItem) map[string]interface{} {
+	return map[string]interface{}{
+		"mpris:trackid":     mprisTrackId(track.Id),
+		"mpris:length":      int64(track.Duration) * 1000000,
+		"xesam:album":       track.Album,
+		"xesam:albumArtist": track.Artist,
+		"xesam:artist":      []string{track.Artist},
+		"xesam:composer":    []string{},
+		"xesam:genre":       []string{},
+		"xesam:title":       track.Title,
+		"xesam:trackNumber": int(0),
+	}
+}
+
 func (m MprisPlayer) Close() {
 	m.conn.Close()
 }
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestMprisTrackId(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"1234", "/org/stmp/track/1234"},
+		{"al-5f3c_2", "/org/stmp/track/al_2d5f3c_5f2"},
+		{"a/b c", "/org/stmp/track/a_2fb_20c"},
+		{"", "/org/mpris/MediaPlayer2/TrackList/NoTrack"},
+	}
+	for _, test := range tests {
+		got := mprisTrackId(test.id)
+		if string(got) != test.want {
+			t.Errorf("mprisTrackId(%q) = %s, want %s", test.id, got, test.want)
+		}
+		if !got.IsValid() {
+			t.Errorf("mprisTrackId(%q) = %s isn't a valid object path", test.id, got)
+		}
+	}
+}
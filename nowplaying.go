@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/rivo/tview"
+	"github.com/spf13/viper"
+)
+
+// size, in pixels, we ask the server to scale covers to. Big enough for a
+// large terminal, small enough to keep the disk cache and sixel output sane.
+const coverArtSize = 600
+
+func (ui *Ui) createNowPlayingPage(titleFlex *tview.Flex) *tview.Flex {
+	protocol := detectGraphicsProtocol(viper.GetString("ui.coverArt"))
+	ui.coverArt = NewCoverArtView(protocol, viper.GetInt("ui.cellWidth"), viper.GetInt("ui.cellHeight"))
+	ui.nowPlayingInfo = tview.NewTextView().
+		SetDynamicColors(true).
+		SetWrap(true)
+	ui.nowPlayingProgress = tview.NewTextView().
+		SetDynamicColors(true)
+
+	infoFlex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(ui.nowPlayingInfo, 0, 1, false).
+		AddItem(ui.nowPlayingProgress, 1, 0, false)
+	infoFlex.SetBorder(true).SetTitle("Now Playing")
+
+	columnFlex := tview.NewFlex().SetDirection(tview.FlexColumn).
+		AddItem(ui.coverArt, 0, 1, false).
+		AddItem(infoFlex, 0, 1, false)
+
+	ui.updateNowPlaying()
+
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(titleFlex, 1, 0, false).
+		AddItem(columnFlex, 0, 1, true)
+}
+
+// updateNowPlaying shows the current track's metadata and starts loading its
+// cover
+func (ui *Ui) updateNowPlaying() {
+	track := ui.player.CurrentTrack()
+	if track == nil {
+		ui.nowPlayingInfo.SetText("[::d]nothing playing")
+		ui.loadCoverArt("")
+		return
+	}
+
+	var info strings.Builder
+	fmt.Fprintf(&info, "[::b]%s[::-]\n\n", tview.Escape(track.Title))
+	fmt.Fprintf(&info, "[green]Artist:[-] %s\n", tview.Escape(track.Artist))
+	if track.Album != "" {
+		fmt.Fprintf(&info, "[green]Album:[-]  %s\n", tview.Escape(track.Album))
+	}
+	if track.Year > 0 {
+		fmt.Fprintf(&info, "[green]Year:[-]   %d\n", track.Year)
+	}
+	if format := formatAudioFormat(track.Suffix, track.BitRate); format != "" {
+		fmt.Fprintf(&info, "[green]Format:[-] %s\n", format)
+	}
+	ui.nowPlayingInfo.SetText(info.String())
+
+	ui.loadCoverArt(track.CoverArt)
+}
+
+// loadCoverArt fetches and decodes a cover in the background. Covers that
+// arrive after the track changed again are dropped.
+func (ui *Ui) loadCoverArt(id string) {
+	ui.coverArtId = id
+	if ui.coverArtCancel != nil {
+		ui.coverArtCancel()
+		ui.coverArtCancel = nil
+	}
+	if id == "" {
+		ui.coverArt.SetImage(nil)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ui.ctx)
+	ui.coverArtCancel = cancel
+	connection := ui.connection
+	go func() {
+		defer cancel()
+		var img image.Image
+		data, err := connection.GetCoverArt(ctx, id, coverArtSize)
+		if err == nil {
+			img, _, err = image.Decode(bytes.NewReader(data))
+		}
+		if err != nil && ctx.Err() == nil {
+			connection.Logger.Printf("loadCoverArt: %s -- %s", id, err.Error())
+		}
+
+		ui.app.QueueUpdateDraw(func() {
+			if ui.coverArtId == id {
+				ui.coverArt.SetImage(img)
+			}
+		})
+	}()
+}
+
+// updateNowPlayingProgress redraws the progress bar to fill the panel width
+func (ui *Ui) updateNowPlayingProgress(position float64, duration float64) {
+	_, _, width, _ := ui.nowPlayingProgress.GetInnerRect()
+
+	positionMin, positionSec := secondsToMinAndSec(position)
+	durationMin, durationSec := secondsToMinAndSec(duration)
+	times := fmt.Sprintf(" %02d:%02d/%02d:%02d", positionMin, positionSec, durationMin, durationSec)
+
+	barWidth := width - len(times)
+	if barWidth <= 0 {
+		ui.nowPlayingProgress.SetText(times)
+		return
+	}
+
+	filled := 0
+	if duration > 0 {
+		filled = int(float64(barWidth) * position / duration)
+	}
+	if filled > barWidth {
+		filled = barWidth
+	} else if filled < 0 {
+		filled = 0
+	}
+
+	ui.nowPlayingProgress.SetText("[green]" + strings.Repeat("━", filled) +
+		"[-::d]" + strings.Repeat("─", barWidth-filled) + "[-::-]" + times)
+}
+
+// formatAudioFormat renders e.g. "FLAC 1024 kbps", leaving out unknown parts
+func formatAudioFormat(suffix string, bitRate int) string {
+	parts := []string{}
+	if suffix != "" {
+		parts = append(parts, strings.ToUpper(suffix))
+	}
+	if bitRate > 0 {
+		parts = append(parts, fmt.Sprintf("%d kbps", bitRate))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Offline mode. When the server can't be reached, requests fail right away
+// with ErrOffline rather than each waiting out the timeout, and stmp makes do
+// with the library and the downloaded songs. Meanwhile the server is pinged
+// every ReconnectInterval until it answers again.
+
+var ErrOffline = errors.New("the server can't be reached")
+
+const defaultReconnectInterval = 30 * time.Second
+
+// isUnreachable reports whether a request that failed with err got no answer
+// from the server: the network failed, or a proxy in front of the server got
+// none either
+func isUnreachable(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// Offline reports whether the server is out of reach
+func (connection *SubsonicConnection) Offline() bool {
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+	return connection.offline
+}
+
+// SetOnOfflineChange sets a function to call, from any goroutine, when the
+// connection goes offline or comes back
+func (connection *SubsonicConnection) SetOnOfflineChange(onChange func(offline bool)) {
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+	connection.onOfflineChange = onChange
+}
+
+// goOffline puts the connection offline, until reconnect finds the server
+func (connection *SubsonicConnection) goOffline() {
+	connection.lock.Lock()
+	if connection.offline || connection.closed {
+		connection.lock.Unlock()
+		return
+	}
+	connection.offline = true
+	connection.lock.Unlock()
+
+	connection.Logger.Printf("goOffline: %s can't be reached, going offline", connection.Host)
+	go connection.reconnect()
+	connection.offlineChanged(true)
+}
+
+// reconnect pings the server until it answers, then puts the connection back
+// online. A connection that went offline before it could Negotiate does that
+// first, so nothing is sent the wrong way.
+func (connection *SubsonicConnection) reconnect() {
+	interval := connection.ReconnectInterval
+	if interval <= 0 {
+		interval = defaultReconnectInterval
+	}
+
+	for {
+		time.Sleep(interval)
+		connection.lock.Lock()
+		closed := connection.closed
+		connection.lock.Unlock()
+		if closed {
+			return
+		}
+		if err := connection.ping(); err != nil && isUnreachable(err) {
+			continue
+		}
+
+		connection.lock.Lock()
+		connection.offline = false
+		negotiated := connection.negotiated
+		connection.lock.Unlock()
+		if !negotiated {
+			// the client's timeout bounds this, nobody is waiting on it
+			if err := connection.Negotiate(context.Background()); err != nil {
+				if connection.Offline() {
+					// gone again, and another reconnect is looking
+					return
+				}
+				connection.Logger.PrintError(err, "reconnect: Negotiate")
+			}
+		}
+
+		connection.Logger.Printf("reconnect: %s is back", connection.Host)
+		if connection.Downloads != nil {
+			connection.Downloads.Resume()
+		}
+		if connection.Scrobbles != nil {
+			connection.Scrobbles.Resume()
+		}
+		connection.offlineChanged(false)
+		return
+	}
+}
+
+// ping asks the server for anything at all, without credentials; an error
+// response still means it is there
+func (connection *SubsonicConnection) ping() error {
+	client := connection.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	requestUrl := connection.Host + "/rest/ping" + "?" + anonymousQuery(connection).Encode()
+	res, err := connection.do(context.Background(), client, requestUrl)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (connection *SubsonicConnection) offlineChanged(offline bool) {
+	connection.lock.Lock()
+	onChange := connection.onOfflineChange
+	connection.lock.Unlock()
+	if onChange != nil {
+		onChange(offline)
+	}
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	//"github.com/wildeyedskies/go-mpv/mpv"
-	"github.com/wildeyedskies/go-mpv/mpv"
-	"strconv"
+	"fmt"
+	"sync"
 )
 
 const (
@@ -18,96 +17,314 @@ type QueueItem struct {
 	Uri      string
 	Title    string
 	Artist   string
+	Album    string
+	Year     int
+	CoverArt string
+	BitRate  int
+	Suffix   string
 	Duration int
 }
 
 type Player struct {
-	Instance          *mpv.Mpv
-	EventChannel      chan *mpv.Event
-	Queue             []QueueItem
-	CurrentIndex      int
-	ReplaceInProgress bool
-}
-
-func eventListener(m *mpv.Mpv) chan *mpv.Event {
-	c := make(chan *mpv.Event)
-	go func() {
-		for {
-			e := m.WaitEvent(1)
-			c <- e
-		}
-	}()
-	return c
+	backend Backend
+	logger  Logger
+	events  eventBus
+
+	// The queue is shared by the UI, the backend event loop, MPRIS and the GPIO
+	// button, which all run on their own goroutines. Only touch it with mu
+	// held, which the methods below take care of.
+	mu                sync.Mutex
+	queue             []QueueItem
+	currentIndex      int
+	replaceInProgress bool
+	// the track the backend is playing, which lags behind currentIndex while a
+	// replacement is loading
+	loadedTrack *QueueItem
+	// the track playing was taken out of the queue, so there is nothing to
+	// drop once it ends
+	currentRemoved bool
+	// see SetResolver
+	resolve func(item QueueItem) string
+	// counts the tracks picked to play, so load can tell a pick that has
+	// been overtaken by a later one
+	picks int
+	// held by load, so tracks are loaded one at a time
+	loadLock sync.Mutex
 }
 
-func InitPlayer() (*Player, error) {
-	mpvInstance := mpv.Create()
+// trackLoad is a track picked to play by playIndex, for load
+type trackLoad struct {
+	uri  string
+	pick int
+}
 
-	// TODO figure out what other mpv options we need
-	mpvInstance.SetOptionString("audio-display", "no")
-	mpvInstance.SetOptionString("video", "no")
+// NewPlayer plays through backend, and owns it from now on. Subscribe to learn
+// what it's doing.
+func NewPlayer(backend Backend, logger Logger) *Player {
+	player := &Player{
+		backend: backend,
+		logger:  logger,
+		queue:   make([]QueueItem, 0),
+	}
+	go player.dispatchEvents()
 
-	err := mpvInstance.Initialize()
-	if err != nil {
-		mpvInstance.TerminateDestroy()
-		return nil, err
+	return player
+}
+
+// Close shuts the backend down, which ends every subscription
+func (p *Player) Close() error {
+	return p.backend.Close()
+}
+
+// Queue returns a copy of the queue
+func (p *Player) Queue() []QueueItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]QueueItem(nil), p.queue...)
+}
+
+func (p *Player) QueueLen() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// QueueItemAt returns a copy of the queue entry at index, or nil
+func (p *Player) QueueItemAt(index int) *QueueItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if index < 0 || index >= len(p.queue) {
+		return nil
+	}
+	item := p.queue[index]
+	return &item
+}
+
+// CurrentTrack returns a copy of the track being played, or nil
+func (p *Player) CurrentTrack() *QueueItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.currentIndex < 0 || p.currentIndex >= len(p.queue) {
+		return nil
 	}
+	track := p.queue[p.currentIndex]
+	return &track
+}
 
-	return &Player{
-        Instance:          mpvInstance,
-        EventChannel:      eventListener(mpvInstance),
-        Queue:             make([]QueueItem, 0),
-        CurrentIndex:      0,
-        ReplaceInProgress: false,
-    }, nil
+func (p *Player) Enqueue(items ...QueueItem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue = append(p.queue, items...)
+	p.publish(PlayerEvent{Type: EventQueueChanged})
 }
 
-func (p *Player) PlayNextTrack() error {
-	  if len(p.Queue) == 0 {
-        return nil // nothing in queue
-    }
-
-	 if p.CurrentIndex+1 >= len(p.Queue) {
-        p.CurrentIndex = 0 // loop back to start
-    } else {
-        p.CurrentIndex++
-    }
-	
-    next := p.Queue[p.CurrentIndex]
-	//p.Queue = []QueueItem{{next.Id, next.Uri, next.Title, next.Artist, next.Duration}}
-    p.ReplaceInProgress = true
-    return p.Instance.Command([]string{"loadfile", next.Uri})
-}
-
-func (p *Player) PlayNextPlaylist() error {
-	if len(p.Queue) > 0 {
-		return p.Instance.Command([]string{"playlist-next-playlist"})
+// ReplaceQueue swaps the queue for items without touching playback
+func (p *Player) ReplaceQueue(items []QueueItem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue = append([]QueueItem(nil), items...)
+	p.currentIndex = 0
+	p.currentRemoved = true
+	p.publish(PlayerEvent{Type: EventQueueChanged})
+}
+
+// RemoveFromQueue takes the track at index out of the queue. If it is the one
+// playing, the track after it plays instead, or playback stops if there is
+// none.
+func (p *Player) RemoveFromQueue(index int) error {
+	p.mu.Lock()
+	if index < 0 || index >= len(p.queue) {
+		p.mu.Unlock()
+		return nil
+	}
+	p.queue = append(p.queue[:index], p.queue[index+1:]...)
+	playing := p.loadedTrack != nil || p.replaceInProgress
+	var next *trackLoad
+	stop := false
+	if index < p.currentIndex {
+		p.currentIndex--
+	} else if index == p.currentIndex {
+		// currentIndex is now the track after it
+		p.currentRemoved = true
+		if playing && len(p.queue) > 0 {
+			if p.currentIndex >= len(p.queue) {
+				p.currentIndex = 0 // loop back to start
+			}
+			pick := p.playIndex(p.currentIndex)
+			next = &pick
+		} else {
+			stop = playing
+		}
+	}
+	p.publish(PlayerEvent{Type: EventQueueChanged})
+	p.mu.Unlock()
+
+	if next != nil {
+		return p.load(*next)
+	}
+	if stop {
+		return p.backend.Stop()
 	}
 	return nil
 }
 
-func (p *Player) Play(id string, uri string, title string, artist string, duration int) error {
-	p.Queue = []QueueItem{{id, uri, title, artist, duration}}
-	p.CurrentIndex = 0
-	p.ReplaceInProgress = true
+func (p *Player) PlayNextTrack() error {
+	p.mu.Lock()
+	if len(p.queue) == 0 {
+		p.mu.Unlock()
+		return nil // nothing in queue
+	}
+
+	if p.currentIndex+1 >= len(p.queue) {
+		p.currentIndex = 0 // loop back to start
+	} else {
+		p.currentIndex++
+	}
+	next := p.playIndex(p.currentIndex)
+	p.mu.Unlock()
+
+	return p.load(next)
+}
+
+func (p *Player) PlayPreviousTrack() error {
+	p.mu.Lock()
+	if len(p.queue) == 0 {
+		p.mu.Unlock()
+		return fmt.Errorf("queue is empty")
+	}
+
+	// move back one track
+	if p.currentIndex > 0 {
+		p.currentIndex--
+	}
+	next := p.playIndex(p.currentIndex)
+	p.mu.Unlock()
+
+	return p.load(next)
+}
+
+func (p *Player) Play(queueItem QueueItem) error {
+	p.mu.Lock()
+	p.queue = []QueueItem{queueItem}
+	p.publish(PlayerEvent{Type: EventQueueChanged})
+	next := p.playIndex(0)
+	p.mu.Unlock()
+
+	return p.load(next)
+}
+
+// PlayQueueIndex starts playing the track at index in the current queue
+func (p *Player) PlayQueueIndex(index int) error {
+	p.mu.Lock()
+	if index < 0 || index >= len(p.queue) {
+		p.mu.Unlock()
+		return nil
+	}
+	next := p.playIndex(index)
+	p.mu.Unlock()
+
+	return p.load(next)
+}
+
+// SetResolver has resolve pick what to load for each track as it is played,
+// such as a downloaded file in place of the stream. An empty answer, or no
+// resolve, loads the track's Uri.
+func (p *Player) SetResolver(resolve func(item QueueItem) string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resolve = resolve
+}
+
+// playIndex makes the track at index the current one, and returns what load
+// has to load for it. It expects mu to be held, and makes no backend calls:
+// a backend may need its events read before it answers, and reading them
+// takes mu.
+func (p *Player) playIndex(index int) trackLoad {
+	p.currentIndex = index
+	p.currentRemoved = false
+	p.replaceInProgress = true
+	uri := p.queue[index].Uri
+	if p.resolve != nil {
+		if resolved := p.resolve(p.queue[index]); resolved != "" {
+			uri = resolved
+		}
+	}
+	p.picks++
+	return trackLoad{uri: uri, pick: p.picks}
+}
+
+// load has the backend play next, unpaused, unless another track has been
+// picked since. It must be called without mu held.
+func (p *Player) load(next trackLoad) error {
+	p.loadLock.Lock()
+	defer p.loadLock.Unlock()
+	p.mu.Lock()
+	overtaken := next.pick != p.picks
+	p.mu.Unlock()
+	if overtaken {
+		return nil
+	}
+
 	if ip, e := p.IsPaused(); ip && e == nil {
-		p.Pause()
+		p.backend.SetPaused(false)
+	}
+	return p.backend.Load(next.uri)
+}
+
+// fileStarted is called by dispatchEvents when the backend starts playing a file. It
+// returns the track that started, or nil.
+func (p *Player) fileStarted() *QueueItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replaceInProgress = false
+	p.loadedTrack = nil
+	if p.currentIndex < 0 || p.currentIndex >= len(p.queue) {
+		return nil
 	}
-	return p.Instance.Command([]string{"loadfile", uri})
+	track := p.queue[p.currentIndex]
+	p.loadedTrack = &track
+	return &track
+}
+
+// fileEnded is called by dispatchEvents when the backend stops playing a file, and
+// returns the track that ended. Unless the file was stopped to load another
+// one, the finished track is dropped, the one that took its place is picked
+// to play next, and advanced is true. Past the end of the queue it starts
+// over.
+func (p *Player) fileEnded() (ended *QueueItem, advanced bool, next *trackLoad) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ended, p.loadedTrack = p.loadedTrack, nil
+	// we don't want to update anything if we're in the process of replacing the current track
+	if p.replaceInProgress {
+		return ended, false, nil
+	}
+	if !p.currentRemoved && p.currentIndex >= 0 && p.currentIndex < len(p.queue) {
+		p.queue = append(p.queue[:p.currentIndex], p.queue[p.currentIndex+1:]...)
+	}
+	if len(p.queue) == 0 {
+		p.currentIndex = 0
+		p.currentRemoved = false
+		return ended, true, nil
+	}
+	if p.currentIndex >= len(p.queue) {
+		p.currentIndex = 0 // loop back to start
+	}
+	pick := p.playIndex(p.currentIndex)
+	return ended, true, &pick
 }
 
 func (p *Player) Stop() error {
-	return p.Instance.Command([]string{"stop"})
+	return p.backend.Stop()
 }
 
 func (p *Player) IsSongLoaded() (bool, error) {
-	idle, err := p.Instance.GetProperty("idle-active", mpv.FORMAT_FLAG)
-	return !idle.(bool), err
+	idle, err := p.backend.Idle()
+	return !idle, err
 }
 
 func (p *Player) IsPaused() (bool, error) {
-	pause, err := p.Instance.GetProperty("pause", mpv.FORMAT_FLAG)
-	return pause.(bool), err
+	return p.backend.Paused()
 }
 
 // Pause toggles playing music
@@ -124,7 +341,7 @@ func (p *Player) Pause() (int, error) {
 	}
 
 	if loaded {
-		err := p.Instance.Command([]string{"cycle", "pause"})
+		err := p.backend.SetPaused(!pause)
 		if err != nil {
 			return PlayerError, err
 		}
@@ -133,26 +350,25 @@ func (p *Player) Pause() (int, error) {
 		}
 		return PlayerPaused, nil
 	} else {
-		if len(p.Queue) != 0 {
-			err := p.Instance.Command([]string{"loadfile", p.Queue[0].Uri})
-			return PlayerPlaying, err
+		p.mu.Lock()
+		if len(p.queue) != 0 {
+			next := p.playIndex(0)
+			p.mu.Unlock()
+			return PlayerPlaying, p.load(next)
 		} else {
+			p.mu.Unlock()
 			return PlayerStopped, nil
 		}
 	}
 }
 
 func (p *Player) AdjustVolume(increment int64) error {
-	volume, err := p.Instance.GetProperty("volume", mpv.FORMAT_INT64)
+	volume, err := p.backend.Volume()
 	if err != nil {
 		return err
 	}
 
-	if volume == nil {
-		return nil
-	}
-
-	nevVolume := volume.(int64) + increment
+	nevVolume := volume + increment
 
 	if nevVolume > 100 {
 		nevVolume = 100
@@ -160,17 +376,19 @@ func (p *Player) AdjustVolume(increment int64) error {
 		nevVolume = 0
 	}
 
-	return p.Instance.SetProperty("volume", mpv.FORMAT_INT64, nevVolume)
+	return p.backend.SetVolume(nevVolume)
 }
 
 func (p *Player) Volume() (int64, error) {
-	volume, err := p.Instance.GetProperty("volume", mpv.FORMAT_INT64)
-	if err != nil {
-		return -1, err
-	}
-	return volume.(int64), nil
+	return p.backend.Volume()
 }
 
 func (p *Player) Seek(increment int) error {
-	return p.Instance.Command([]string{"seek", strconv.Itoa(increment)})
+	return p.backend.Seek(increment)
+}
+
+// SetStreamHeaders sets the HTTP headers sent along with every track from now
+// on, see SubsonicConnection.StreamHeaders
+func (p *Player) SetStreamHeaders(headers []string) error {
+	return p.backend.SetHeaders(headers)
 }
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestPlayer returns a player on a FakeBackend, closed when the test ends
+func newTestPlayer(t *testing.T) (*Player, *FakeBackend) {
+	backend := NewFakeBackend()
+	player := NewPlayer(backend, testLogger())
+	t.Cleanup(func() { player.Close() })
+	return player, backend
+}
+
+func testQueueItems(n int) []QueueItem {
+	items := make([]QueueItem, n)
+	for i := range items {
+		id := strconv.Itoa(i)
+		items[i] = QueueItem{Id: id, Uri: "song-" + id, Title: "Song " + id}
+	}
+	return items
+}
+
+// TestPlayerConcurrentUse has the UI, MPRIS, the GPIO button and the backend
+// all at the queue at once; run it with -race
+func TestPlayerConcurrentUse(t *testing.T) {
+	player, backend := newTestPlayer(t)
+	events, unsubscribe := player.Subscribe()
+	defer unsubscribe()
+	go func() {
+		for range events {
+		}
+	}()
+
+	const rounds = 200
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				f(i)
+			}
+		}()
+	}
+
+	run(func(i int) { player.Enqueue(testQueueItems(2)...) })
+	run(func(i int) { player.RemoveFromQueue(0) })
+	run(func(i int) {
+		player.Queue()
+		player.QueueLen()
+		player.CurrentTrack()
+		player.QueueItemAt(i % 3)
+	})
+	run(func(i int) {
+		if i%2 == 0 {
+			player.PlayNextTrack()
+		} else {
+			player.PlayPreviousTrack()
+		}
+	})
+	run(func(i int) { player.PlayQueueIndex(i % 4) })
+	run(func(i int) { backend.Finish() })
+	run(func(i int) { player.Pause() })
+	run(func(i int) {
+		if i%50 == 0 {
+			player.ReplaceQueue(testQueueItems(3))
+		}
+	})
+	wg.Wait()
+
+	player.ReplaceQueue(nil)
+	if n := player.QueueLen(); n != 0 {
+		t.Errorf("queue has %d items after ReplaceQueue(nil)", n)
+	}
+}
+
+func TestQueueReadsReturnCopies(t *testing.T) {
+	player, _ := newTestPlayer(t)
+	player.Enqueue(testQueueItems(2)...)
+
+	queue := player.Queue()
+	queue[0].Title = "changed"
+	if item := player.QueueItemAt(0); item == nil || item.Title != "Song 0" {
+		t.Errorf("changing the copy from Queue changed the queue: %+v", item)
+	}
+
+	item := player.QueueItemAt(1)
+	item.Title = "changed"
+	if again := player.QueueItemAt(1); again == nil || again.Title != "Song 1" {
+		t.Errorf("changing the copy from QueueItemAt changed the queue: %+v", again)
+	}
+	if player.QueueItemAt(2) != nil || player.QueueItemAt(-1) != nil {
+		t.Error("QueueItemAt returned an item out of range")
+	}
+}
+
+// TestSlowSubscriberKeepsLifecycleEvents publishes far more than a subscriber
+// reads; it must still see every track start and end, in order
+func TestSlowSubscriberKeepsLifecycleEvents(t *testing.T) {
+	var player Player
+	events, _ := player.Subscribe()
+
+	const tracks = 100
+	for i := 0; i < tracks; i++ {
+		track := &QueueItem{Id: strconv.Itoa(i)}
+		player.publish(PlayerEvent{Type: EventTrackStarted, Track: track})
+		for volume := 0; volume <= 50; volume++ {
+			player.publish(PlayerEvent{Type: EventVolumeChanged, Volume: int64(volume)})
+		}
+		for position := 0; position <= 50; position++ {
+			player.publish(PlayerEvent{Type: EventPositionChanged, Position: float64(position)})
+		}
+		player.publish(PlayerEvent{Type: EventTrackEnded, Track: track, Finished: true})
+	}
+	player.closeSubscribers()
+
+	var got []PlayerEvent
+	for event := range events {
+		got = append(got, event)
+	}
+
+	var lifecycle []string
+	positions := 0
+	for _, event := range got {
+		switch event.Type {
+		case EventTrackStarted, EventTrackEnded:
+			lifecycle = append(lifecycle, event.Type.String()+" "+event.Track.Id)
+		case EventPositionChanged:
+			positions++
+		}
+	}
+	if len(lifecycle) != 2*tracks {
+		t.Fatalf("got %d track starts and ends, want %d", len(lifecycle), 2*tracks)
+	}
+	for i := 0; i < tracks; i++ {
+		id := strconv.Itoa(i)
+		if lifecycle[2*i] != "TrackStarted "+id || lifecycle[2*i+1] != "TrackEnded "+id {
+			t.Fatalf("track %d: got %q, %q", i, lifecycle[2*i], lifecycle[2*i+1])
+		}
+	}
+	// nobody was reading, so each run of positions is down to its last one,
+	// give or take the event already on its way
+	if positions > tracks+1 {
+		t.Errorf("got %d position events for %d tracks", positions, tracks)
+	}
+	// the last of every run of changes gets through
+	last := got[len(got)-2]
+	if last.Type != EventPositionChanged || last.Position != 50 {
+		t.Errorf("got %+v before the last track ended, want position 50", last)
+	}
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	var player Player
+	events, unsubscribe := player.Subscribe()
+	player.publish(PlayerEvent{Type: EventPaused})
+	unsubscribe()
+	unsubscribe()
+	player.publish(PlayerEvent{Type: EventResumed})
+
+	for event := range events {
+		if event.Type == EventResumed {
+			t.Error("got an event published after unsubscribing")
+		}
+	}
+}
+
+// waitFor returns the next event of type want, skipping others
+func waitFor(t *testing.T, events <-chan PlayerEvent, want PlayerEventType) PlayerEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("events closed waiting for %s", want)
+			}
+			if event.Type == want {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", want)
+		}
+	}
+}
+
+// queueIds lists the ids in the queue, for comparing
+func queueIds(player *Player) string {
+	var ids []string
+	for _, item := range player.Queue() {
+		ids = append(ids, item.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestPlayerAdvancesWhenTrackFinishes(t *testing.T) {
+	player, backend := newTestPlayer(t)
+	events, unsubscribe := player.Subscribe()
+	defer unsubscribe()
+	player.Enqueue(testQueueItems(3)...)
+
+	if err := player.PlayQueueIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	if started := waitFor(t, events, EventTrackStarted); started.Track == nil || started.Track.Id != "0" {
+		t.Fatalf("started %+v, want song 0", started.Track)
+	}
+
+	for _, next := range []string{"1", "2"} {
+		backend.Finish()
+		ended := waitFor(t, events, EventTrackEnded)
+		if !ended.Finished {
+			t.Error("a track played to the end isn't Finished")
+		}
+		started := waitFor(t, events, EventTrackStarted)
+		if started.Track == nil || started.Track.Id != next {
+			t.Fatalf("started %+v, want song %s", started.Track, next)
+		}
+	}
+	if got := queueIds(player); got != "2" {
+		t.Errorf("queue is %q, want the finished songs dropped", got)
+	}
+
+	backend.Finish()
+	waitFor(t, events, EventTrackEnded)
+	waitFor(t, events, EventQueueChanged)
+	if n := player.QueueLen(); n != 0 {
+		t.Errorf("queue has %d songs after the last one finished", n)
+	}
+	if got := strings.Join(backend.Loaded(), ","); got != "song-0,song-1,song-2" {
+		t.Errorf("loaded %s", got)
+	}
+}
+
+func TestPlayerReplacingTrackKeepsQueue(t *testing.T) {
+	player, _ := newTestPlayer(t)
+	events, unsubscribe := player.Subscribe()
+	defer unsubscribe()
+	player.Enqueue(testQueueItems(3)...)
+
+	player.PlayQueueIndex(0)
+	waitFor(t, events, EventTrackStarted)
+	player.PlayQueueIndex(2)
+
+	ended := waitFor(t, events, EventTrackEnded)
+	if ended.Finished || ended.Track == nil || ended.Track.Id != "0" {
+		t.Errorf("ended %+v finished %v, want song 0 not finished", ended.Track, ended.Finished)
+	}
+	if started := waitFor(t, events, EventTrackStarted); started.Track == nil || started.Track.Id != "2" {
+		t.Errorf("started %+v, want song 2", started.Track)
+	}
+	if got := queueIds(player); got != "0,1,2" {
+		t.Errorf("queue is %q, replacing a track mustn't drop any", got)
+	}
+}
+
+func TestRemoveFromQueue(t *testing.T) {
+	tests := []struct {
+		name string
+		// songs skipped with PlayNextTrack after playing the first
+		skipped int
+		remove  int
+		// queue after removing, and the song that plays next: straight away
+		// if the playing one was removed, else once it finishes
+		removed string
+		next    string
+		// queue once next has started
+		after string
+	}{
+		{"the playing song, first", 0, 0, "1,2,3", "1", "1,2,3"},
+		{"the playing song, in the middle", 1, 1, "0,2,3", "2", "0,2,3"},
+		{"the playing song, last", 3, 3, "0,1,2", "0", "0,1,2"},
+		{"before the playing song", 2, 0, "1,2,3", "3", "1,3"},
+		{"after the playing song", 1, 2, "0,1,3", "3", "0,3"},
+		{"the first row, with a later one playing", 1, 0, "1,2,3", "2", "2,3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			player, backend := newTestPlayer(t)
+			events, unsubscribe := player.Subscribe()
+			defer unsubscribe()
+			player.Enqueue(testQueueItems(4)...)
+			player.PlayQueueIndex(0)
+			waitFor(t, events, EventTrackStarted)
+			for i := 0; i < test.skipped; i++ {
+				player.PlayNextTrack()
+				waitFor(t, events, EventTrackStarted)
+			}
+			playing := player.CurrentTrack().Id
+
+			if err := player.RemoveFromQueue(test.remove); err != nil {
+				t.Fatal(err)
+			}
+			if got := queueIds(player); got != test.removed {
+				t.Errorf("queue is %q after removing, want %q", got, test.removed)
+			}
+
+			if strconv.Itoa(test.remove) != playing {
+				backend.Finish()
+			}
+			ended := waitFor(t, events, EventTrackEnded)
+			if ended.Track == nil || ended.Track.Id != playing {
+				t.Errorf("ended %+v, want song %s", ended.Track, playing)
+			}
+			started := waitFor(t, events, EventTrackStarted)
+			if started.Track == nil || started.Track.Id != test.next {
+				t.Errorf("started %+v, want song %s", started.Track, test.next)
+			}
+			if got := queueIds(player); got != test.after {
+				t.Errorf("queue is %q once song %s started, want %q", got, test.next, test.after)
+			}
+		})
+	}
+}
+
+func TestRemovingTheOnlySongStops(t *testing.T) {
+	player, backend := newTestPlayer(t)
+	events, unsubscribe := player.Subscribe()
+	defer unsubscribe()
+	player.Enqueue(testQueueItems(1)...)
+	player.PlayQueueIndex(0)
+	waitFor(t, events, EventTrackStarted)
+
+	player.RemoveFromQueue(0)
+	waitFor(t, events, EventTrackEnded)
+	if idle, _ := backend.Idle(); !idle {
+		t.Error("still playing with the queue empty")
+	}
+	if n := player.QueueLen(); n != 0 {
+		t.Errorf("queue has %d songs", n)
+	}
+}
+
+// TestPlayerReadsEventsWhileLoading plays on a backend that can't answer
+// until its events are read, as mpv's IPC socket can't once its buffer is
+// full: loading must not hold up reading them
+func TestPlayerReadsEventsWhileLoading(t *testing.T) {
+	backend := NewUnbufferedFakeBackend()
+	player := NewPlayer(backend, testLogger())
+	t.Cleanup(func() { player.Close() })
+	events, unsubscribe := player.Subscribe()
+	defer unsubscribe()
+	player.Enqueue(testQueueItems(4)...)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		player.PlayQueueIndex(0)
+		player.PlayNextTrack()
+		player.Seek(5)
+		backend.Finish()
+		player.PlayPreviousTrack()
+		player.RemoveFromQueue(0)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("player and backend are waiting on each other")
+	}
+	waitFor(t, events, EventTrackStarted)
+}
+
+func TestPlayerPublishesEvents(t *testing.T) {
+	player, backend := newTestPlayer(t)
+	events, unsubscribe := player.Subscribe()
+	defer unsubscribe()
+
+	steps := []struct {
+		want  PlayerEventType
+		do    func()
+		check func(event PlayerEvent) bool
+	}{
+		{EventQueueChanged, func() { player.Enqueue(testQueueItems(2)...) }, nil},
+		{EventTrackStarted, func() { player.PlayQueueIndex(0) }, func(event PlayerEvent) bool {
+			return event.Track != nil && event.Track.Id == "0"
+		}},
+		{EventPositionChanged, func() { backend.Progress(12) }, func(event PlayerEvent) bool {
+			return event.Position == 12
+		}},
+		{EventSeeked, func() { player.Seek(10) }, func(event PlayerEvent) bool {
+			return event.Position == 22
+		}},
+		{EventPaused, func() { player.Pause() }, nil},
+		{EventResumed, func() { player.Pause() }, nil},
+		{EventVolumeChanged, func() { player.AdjustVolume(-10) }, func(event PlayerEvent) bool {
+			return event.Volume == 90
+		}},
+		{EventTrackEnded, func() { backend.Finish() }, func(event PlayerEvent) bool {
+			return event.Finished && event.Track != nil && event.Track.Id == "0"
+		}},
+		{EventTrackStarted, func() {}, func(event PlayerEvent) bool {
+			return event.Track != nil && event.Track.Id == "1"
+		}},
+	}
+	for _, step := range steps {
+		step.do()
+		event := waitFor(t, events, step.want)
+		if step.check != nil && !step.check(event) {
+			t.Errorf("%s: got %+v", step.want, event)
+		}
+	}
+
+	// closing the backend ends every subscription
+	player.Close()
+	timeout := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-events:
+			closed = !ok
+		case <-timeout:
+			t.Fatal("events still open after Close")
+		}
+	}
+}
+
+// TestResolverPicksWhatToLoad asks the resolver as each track starts, so a
+// song downloaded while queued plays from disk
+func TestResolverPicksWhatToLoad(t *testing.T) {
+	player, backend := newTestPlayer(t)
+	events, unsubscribe := player.Subscribe()
+	defer unsubscribe()
+	player.Enqueue(testQueueItems(2)...)
+
+	downloaded := map[string]string{}
+	var lock sync.Mutex
+	player.SetResolver(func(item QueueItem) string {
+		lock.Lock()
+		defer lock.Unlock()
+		return downloaded[item.Id]
+	})
+
+	player.PlayQueueIndex(0)
+	waitFor(t, events, EventTrackStarted)
+	lock.Lock()
+	downloaded["1"] = "/downloads/1.flac"
+	lock.Unlock()
+	backend.Finish()
+	waitFor(t, events, EventTrackStarted)
+
+	if got := strings.Join(backend.Loaded(), ","); got != "song-0,/downloads/1.flac" {
+		t.Errorf("loaded %s", got)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rivo/tview"
+)
+
+// createPlaylistFormPage is the dialog for editing a playlist, see
+// showEditPlaylist, or saving the queue as one, see showSaveQueue
+func (ui *Ui) createPlaylistFormPage() tview.Primitive {
+	ui.playlistForm = tview.NewForm()
+	ui.playlistForm.SetBorder(true)
+	ui.playlistForm.SetCancelFunc(ui.hidePlaylistForm)
+	return makeModal(ui.playlistForm, 60, 11)
+}
+
+// showPlaylistForm opens the dialog, once its fields are set up
+func (ui *Ui) showPlaylistForm(title string) {
+	ui.playlistForm.SetTitle(title)
+	ui.playlistFormReturnFocus = ui.app.GetFocus()
+	ui.pages.ShowPage("playlistForm")
+	ui.app.SetFocus(ui.playlistForm)
+}
+
+func (ui *Ui) hidePlaylistForm() {
+	ui.pages.HidePage("playlistForm")
+	if ui.playlistFormReturnFocus != nil {
+		ui.app.SetFocus(ui.playlistFormReturnFocus)
+	}
+}
+
+// showEditPlaylist opens the dialog for the highlighted playlist
+func (ui *Ui) showEditPlaylist() {
+	playlist, ok := ui.highlightedPlaylist()
+	if !ok {
+		return
+	}
+
+	name, comment, public := playlist.Name, playlist.Comment, playlist.Public
+	ui.playlistForm.Clear(true).
+		AddInputField("Name", name, 40, nil, func(text string) { name = text }).
+		AddInputField("Comment", comment, 40, nil, func(text string) { comment = text }).
+		AddCheckbox("Public", public, func(checked bool) { public = checked }).
+		AddButton("Save", func() {
+			ui.hidePlaylistForm()
+			ui.updatePlaylist("showEditPlaylist", string(playlist.Id), PlaylistUpdate{Name: &name, Comment: &comment, Public: &public})
+		}).
+		AddButton("Cancel", ui.hidePlaylistForm)
+	ui.showPlaylistForm("Edit playlist")
+}
+
+// showSaveQueue opens the dialog for saving the queue as a new playlist, or
+// in place of the songs of an existing one
+func (ui *Ui) showSaveQueue() {
+	queue := ui.player.Queue()
+	if len(queue) == 0 {
+		return
+	}
+	songIds := make([]string, len(queue))
+	songs := make(SubsonicEntities, len(queue))
+	for i, item := range queue {
+		songIds[i] = item.Id
+		songs[i] = SubsonicEntity{
+			Id:       item.Id,
+			Title:    item.Title,
+			Artist:   item.Artist,
+			Album:    item.Album,
+			CoverArt: item.CoverArt,
+			Year:     item.Year,
+			BitRate:  item.BitRate,
+			Suffix:   item.Suffix,
+			Duration: item.Duration,
+		}
+	}
+
+	// the playlists may be refreshed while the dialog is open
+	playlists := append([]SubsonicPlaylist(nil), ui.playlists...)
+	options := []string{"new playlist"}
+	for _, playlist := range playlists {
+		options = append(options, playlist.Name)
+	}
+	target := 0
+	name := ""
+	ui.playlistForm.Clear(true).
+		AddDropDown("Save as", options, 0, func(_ string, index int) { target = index }).
+		AddInputField("Name", "", 40, nil, func(text string) { name = text }).
+		AddButton("Save", func() {
+			if target == 0 && name == "" {
+				return
+			}
+			ui.hidePlaylistForm()
+			if target == 0 {
+				ui.saveQueueAsPlaylist(name, songIds)
+			} else {
+				ui.replacePlaylistSongs(string(playlists[target-1].Id), songs)
+			}
+		}).
+		AddButton("Cancel", ui.hidePlaylistForm)
+	ui.showPlaylistForm("Save queue as playlist")
+}
+
+// saveQueueAsPlaylist creates playlist name from songIds
+func (ui *Ui) saveQueueAsPlaylist(name string, songIds []string) {
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		response, err := connection.CreatePlaylist(ctx, name, songIds...)
+		if response == nil || response.Status != "ok" {
+			return func() { connection.Logger.PrintError(err, "saveQueueAsPlaylist: CreatePlaylist %s", name) }
+		}
+
+		// the playlist is there even if adding some of the songs failed
+		show := ui.refreshLibrary
+		if response.Playlist.Id != "" {
+			show = ui.refreshPlaylist(ctx, connection, string(response.Playlist.Id), -1)
+		}
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, "saveQueueAsPlaylist: CreatePlaylist %s", name)
+			}
+			show()
+		}
+	})
+}
+
+// replacePlaylistSongs overwrites the songs of playlist id with songs
+func (ui *Ui) replacePlaylistSongs(id string, songs SubsonicEntities) {
+	ui.editPlaylist("replacePlaylistSongs", id, 0, func(playlist *SubsonicPlaylist) {
+		playlist.Entries = songs
+	}, replaceSongs)
+}
+
+// showAddToPlaylist opens the list of playlists; selected is called with the
+// one picked
+func (ui *Ui) showAddToPlaylist(selected func(playlist *SubsonicPlaylist)) {
+	ui.addToPlaylistSelected = selected
+	ui.addToPlaylistReturnFocus = ui.app.GetFocus()
+	ui.pages.ShowPage("addToPlaylist")
+	ui.app.SetFocus(ui.addToPlaylistList)
+}
+
+func (ui *Ui) hideAddToPlaylist() {
+	ui.pages.HidePage("addToPlaylist")
+	if ui.addToPlaylistReturnFocus != nil {
+		ui.app.SetFocus(ui.addToPlaylistReturnFocus)
+	}
+}
+
+// handleAddQueueItemToPlaylist has the song highlighted in the queue added to
+// the playlist picked next
+func (ui *Ui) handleAddQueueItemToPlaylist() {
+	if item := ui.player.QueueItemAt(ui.queueList.GetCurrentItem()); item != nil {
+		ui.addQueueItemToPlaylist("handleAddQueueItemToPlaylist", *item)
+	}
+}
+
+// handleAddCurrentToPlaylist has the song being played added to the playlist
+// picked next
+func (ui *Ui) handleAddCurrentToPlaylist() {
+	if item := ui.player.CurrentTrack(); item != nil {
+		ui.addQueueItemToPlaylist("handleAddCurrentToPlaylist", *item)
+	}
+}
+
+func (ui *Ui) addQueueItemToPlaylist(caller string, item QueueItem) {
+	song := SubsonicEntity{Id: item.Id, Title: item.Title, Artist: item.Artist}
+	ui.showAddToPlaylist(func(playlist *SubsonicPlaylist) {
+		ui.addSongsToPlaylist(caller+": "+item.Title, playlist, func(ctx context.Context, _ *SubsonicConnection) (SubsonicEntities, error) {
+			return SubsonicEntities{song}, nil
+		})
+	})
+}
+
+// addSongsToPlaylist fetches songs off the UI goroutine, like
+// enqueueInBackground, and appends them to playlist, in one request
+// where the server allows, see UpdatePlaylist
+func (ui *Ui) addSongsToPlaylist(caller string, playlist *SubsonicPlaylist, fetch func(ctx context.Context, connection *SubsonicConnection) (SubsonicEntities, error)) {
+	id := string(playlist.Id)
+	connection := ui.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		songs, err := fetch(ctx, connection)
+		return func() {
+			if err != nil {
+				connection.Logger.PrintError(err, "%s", caller)
+				return
+			}
+			if ui.connection != connection {
+				return
+			}
+			var added SubsonicEntities
+			var songIds []string
+			for _, song := range songs {
+				if !song.IsDirectory {
+					added = append(added, song)
+					songIds = append(songIds, song.Id)
+				}
+			}
+			if len(songIds) == 0 {
+				connection.Logger.Printf("%s: no songs to add", caller)
+				return
+			}
+
+			ui.editPlaylist(caller, id, -1, func(playlist *SubsonicPlaylist) {
+				playlist.Entries = append(playlist.Entries, added...)
+			}, func(ctx context.Context, connection *SubsonicConnection, _ SubsonicPlaylist) error {
+				return connection.UpdatePlaylist(ctx, id, PlaylistUpdate{SongIdsToAdd: songIds})
+			})
+		}
+	})
+}
+
+func (ui *Ui) highlightedPlaylist() (SubsonicPlaylist, bool) {
+	index := ui.playlistList.GetCurrentItem()
+	if index < 0 || index >= len(ui.playlists) {
+		return SubsonicPlaylist{}, false
+	}
+	return ui.playlists[index], true
+}
+
+func (ui *Ui) handleTogglePlaylistPublic() {
+	playlist, ok := ui.highlightedPlaylist()
+	if !ok {
+		return
+	}
+	public := !playlist.Public
+	ui.updatePlaylist("handleTogglePlaylistPublic", string(playlist.Id), PlaylistUpdate{Public: &public})
+}
+
+// handleRemoveFromPlaylist removes the highlighted song from its playlist
+func (ui *Ui) handleRemoveFromPlaylist() {
+	playlist, ok := ui.highlightedPlaylist()
+	index := ui.selectedPlaylist.GetCurrentItem()
+	if !ok || index < 0 || index >= len(playlist.Entries) {
+		return
+	}
+	id := string(playlist.Id)
+	ui.editPlaylist("handleRemoveFromPlaylist", id, index, func(playlist *SubsonicPlaylist) {
+		playlist.Entries = append(playlist.Entries[:index], playlist.Entries[index+1:]...)
+	}, func(ctx context.Context, connection *SubsonicConnection, _ SubsonicPlaylist) error {
+		return connection.UpdatePlaylist(ctx, id, PlaylistUpdate{SongIndexesToRemove: []int{index}})
+	})
+}
+
+// handleMoveInPlaylist moves the highlighted song up (-1) or down (1). The
+// server can't move songs, so the playlist is sent again in the new order.
+func (ui *Ui) handleMoveInPlaylist(delta int) {
+	playlist, ok := ui.highlightedPlaylist()
+	from := ui.selectedPlaylist.GetCurrentItem()
+	to := from + delta
+	if !ok || from < 0 || from >= len(playlist.Entries) || to < 0 || to >= len(playlist.Entries) {
+		return
+	}
+
+	ui.editPlaylist("handleMoveInPlaylist", string(playlist.Id), to, func(playlist *SubsonicPlaylist) {
+		playlist.Entries[from], playlist.Entries[to] = playlist.Entries[to], playlist.Entries[from]
+	}, replaceSongs)
+}
+
+// replaceSongs sends the songs of edited in place of the playlist's, for
+// changes the server has no request for
+func replaceSongs(ctx context.Context, connection *SubsonicConnection, edited SubsonicPlaylist) error {
+	songIds := make([]string, len(edited.Entries))
+	for i, entity := range edited.Entries {
+		songIds[i] = entity.Id
+	}
+	_, err := connection.ReplacePlaylist(ctx, string(edited.Id), songIds)
+	return err
+}
+
+// updatePlaylist changes the name, comment or visibility of playlist id
+func (ui *Ui) updatePlaylist(caller string, id string, update PlaylistUpdate) {
+	ui.editPlaylist(caller, id, -1, func(playlist *SubsonicPlaylist) {
+		if update.Name != nil {
+			playlist.Name = *update.Name
+		}
+		if update.Comment != nil {
+			playlist.Comment = *update.Comment
+		}
+		if update.Public != nil {
+			playlist.Public = *update.Public
+		}
+	}, func(ctx context.Context, connection *SubsonicConnection, _ SubsonicPlaylist) error {
+		return connection.UpdatePlaylist(ctx, id, update)
+	})
+}
+
+// playlistEdit is a change to a playlist waiting to be sent, see editPlaylist
+type playlistEdit struct {
+	caller     string
+	connection *SubsonicConnection
+	edited     SubsonicPlaylist
+	current    int
+	send       func(ctx context.Context, connection *SubsonicConnection, edited SubsonicPlaylist) error
+}
+
+// editPlaylist makes change to a copy of playlist id, shows it at once, with
+// song current highlighted if it isn't -1, and has send make the change on
+// the server. Each edit starts from where the last left off, even before the
+// server has it, and the edits to a playlist are sent one at a time in the
+// order they were made: sent side by side, a move pressed twice would go out
+// twice the same, and a move sent after a remove would put the song back.
+func (ui *Ui) editPlaylist(caller string, id string, current int, change func(playlist *SubsonicPlaylist), send func(ctx context.Context, connection *SubsonicConnection, edited SubsonicPlaylist) error) {
+	var edited SubsonicPlaylist
+	found := false
+	for _, playlist := range ui.playlists {
+		if string(playlist.Id) == id {
+			edited, found = playlist, true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+	edited.Entries = append(SubsonicEntities(nil), edited.Entries...)
+	change(&edited)
+	edited.SongCount = len(edited.Entries)
+	ui.replacePlaylist(edited, current)
+
+	if ui.playlistEdits == nil {
+		ui.playlistEdits = make(map[string][]playlistEdit)
+	}
+	ui.playlistEdits[id] = append(ui.playlistEdits[id], playlistEdit{caller, ui.connection, edited, current, send})
+	if len(ui.playlistEdits[id]) == 1 {
+		ui.sendPlaylistEdit(id)
+	}
+}
+
+// sendPlaylistEdit sends the first edit waiting for playlist id, then the
+// next. The playlist is fetched again after each, but only shown once there
+// are no more to send, so it doesn't jump back to before the edits waiting.
+// When one fails those after it are dropped, as they build on it.
+func (ui *Ui) sendPlaylistEdit(id string) {
+	edit := ui.playlistEdits[id][0]
+	connection := edit.connection
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		err := edit.send(ctx, connection, edit.edited)
+		refresh := ui.refreshPlaylist(ctx, connection, id, edit.current)
+		return func() {
+			waiting := ui.playlistEdits[id][1:]
+			if err != nil {
+				connection.Logger.PrintError(err, "%s: %s", edit.caller, edit.edited.Name)
+				waiting = nil
+			}
+			if len(waiting) > 0 {
+				ui.playlistEdits[id] = waiting
+				ui.sendPlaylistEdit(id)
+				return
+			}
+			delete(ui.playlistEdits, id)
+			refresh()
+		}
+	})
+}
+
+// refreshPlaylist fetches just playlist id through connection after a change,
+// and returns what shows it, for runInBackground. connection is the one the
+// change went through, taken before leaving the UI goroutine.
+func (ui *Ui) refreshPlaylist(ctx context.Context, connection *SubsonicConnection, id string, current int) func() {
+	playlist, err := connection.RefreshPlaylist(ctx, id)
+	return func() {
+		if err != nil {
+			connection.Logger.PrintError(err, "refreshPlaylist: GetPlaylist %s", id)
+			return
+		}
+		if ui.connection == connection {
+			ui.replacePlaylist(*playlist, current)
+		}
+	}
+}
+
+// replacePlaylist puts playlist in place of the one with its id, or adds it
+func (ui *Ui) replacePlaylist(playlist SubsonicPlaylist, current int) {
+	index := -1
+	for i, old := range ui.playlists {
+		if old.Id == playlist.Id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		ui.playlists = append(ui.playlists, playlist)
+		ui.playlistList.AddItem(playlist.Name, "", 0, nil)
+		ui.addToPlaylistList.AddItem(playlist.Name, "", 0, nil)
+		return
+	}
+
+	ui.playlists[index] = playlist
+	ui.playlistList.SetItemText(index, playlist.Name, "")
+	ui.addToPlaylistList.SetItemText(index, playlist.Name, "")
+	if index != ui.playlistList.GetCurrentItem() {
+		return
+	}
+
+	if current < 0 {
+		current = ui.selectedPlaylist.GetCurrentItem()
+	}
+	ui.handlePlaylistSelected(playlist)
+	if current >= ui.selectedPlaylist.GetItemCount() {
+		current = ui.selectedPlaylist.GetItemCount() - 1
+	}
+	if current >= 0 {
+		ui.selectedPlaylist.SetCurrentItem(current)
+	}
+}
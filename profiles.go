@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// Profiles are named servers, configured as [profiles.<name>] with the same
+// server and auth tables as the top level:
+//
+//	[profiles.home.server]
+//	host = 'https://navidrome.home'
+//	[profiles.home.auth]
+//	username = 'me'
+//
+// Whatever a profile leaves out is taken from the top level. Without any
+// profiles there is just the top level, which is the unnamed profile "".
+
+// profileNames lists the configured profiles, sorted
+func profileNames() []string {
+	var names []string
+	for name := range viper.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// defaultProfile is the profile to start with when --profile isn't given
+func defaultProfile() string {
+	if name := viper.GetString("profile"); name != "" {
+		return name
+	}
+	if names := profileNames(); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// profileKey is the config key for setting key in profile name
+func profileKey(name, key string) string {
+	if name != "" && viper.IsSet("profiles."+name+"."+key) {
+		return "profiles." + name + "." + key
+	}
+	return key
+}
+
+func profileString(name, key string) string {
+	return viper.GetString(profileKey(name, key))
+}
+
+func profileBool(name, key string) bool {
+	return viper.GetBool(profileKey(name, key))
+}
+
+// connectProfile builds the connection for profile name and negotiates with
+// the server. If the server can't be reached the connection starts offline.
+// Only an interactive caller may prompt for the password, see
+// resolvePassword.
+func connectProfile(ctx context.Context, name string, logger Logger, interactive bool) (*SubsonicConnection, error) {
+	if name != "" && !viper.IsSet("profiles."+name) {
+		return nil, fmt.Errorf("there is no profile %q", name)
+	}
+
+	host := profileString(name, "server.host")
+	username := profileString(name, "auth.username")
+	apiKey := profileString(name, "auth.apiKey")
+
+	if profileString(name, "server.streamAuth") == "header" {
+		return nil, errors.New("server.streamAuth = 'header' is now server.proxyBasicAuth = true")
+	}
+	// the proxy wants the password even where the server takes an API key
+	proxyBasicAuth := profileBool(name, "server.proxyBasicAuth")
+	password := ""
+	if apiKey == "" || proxyBasicAuth {
+		var err error
+		password, err = resolvePassword(host, username, profileString(name, "auth.password"),
+			profileString(name, "auth.passwordCommand"), interactive)
+		if err != nil {
+			return nil, fmt.Errorf("getting the password: %w", err)
+		}
+	}
+
+	// servers have their own ids, their cover art mustn't mix
+	cacheDir := cacheDirectory()
+	if name != "" && cacheDir != "" {
+		cacheDir = filepath.Join(cacheDir, name)
+	}
+
+	connection := &SubsonicConnection{
+		Username:          username,
+		Password:          password,
+		Host:              host,
+		PlaintextAuth:     profileBool(name, "auth.plaintext"),
+		ApiKey:            apiKey,
+		ProxyBasicAuth:    proxyBasicAuth,
+		Scrobble:          profileBool(name, "server.scrobble"),
+		Logger:            logger,
+		CacheDir:          cacheDir,
+		Client:            NewHTTPClient(viper.GetDuration("server.timeout"), viper.GetBool("server.keepAlive")),
+		UserAgent:         "stmp",
+		Retries:           viper.GetInt("server.retries"),
+		ReconnectInterval: viper.GetDuration("server.reconnectInterval"),
+	}
+	if err := connection.CheckProxyBasicAuth(); err != nil {
+		return nil, err
+	}
+	libraryDir := ""
+	if cacheDir != "" {
+		libraryDir = filepath.Join(cacheDir, "library")
+	}
+	connection.Library = NewLibrary(libraryDir, viper.GetDuration("cache.maxAge"))
+	if cacheDir != "" {
+		maxSize := viper.GetInt64("downloads.maxSize") << 20
+		connection.Downloads = NewDownloads(filepath.Join(cacheDir, "downloads"), maxSize, connection)
+	}
+	if connection.Scrobble {
+		scrobblesPath := ""
+		if cacheDir != "" {
+			scrobblesPath = filepath.Join(cacheDir, "scrobbles.json")
+		}
+		connection.Scrobbles = NewScrobbleQueue(scrobblesPath, logger, subsonicScrobbler{connection}.Submit)
+	}
+
+	if err := connection.Negotiate(ctx); err != nil {
+		// a server that can't be reached is negotiated with once it's back
+		if connection.Offline() {
+			logger.Printf("connectProfile: starting offline -- %s", err.Error())
+			return connection, nil
+		}
+		connection.Close()
+		return nil, err
+	}
+	return connection, nil
+}
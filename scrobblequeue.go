@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PendingScrobble is a play a Scrobbler hasn't been told about yet
+type PendingScrobble struct {
+	Id       string    `json:"id"`
+	Title    string    `json:"title,omitempty"`
+	Artist   string    `json:"artist,omitempty"`
+	Album    string    `json:"album,omitempty"`
+	Duration int       `json:"duration,omitempty"` // in seconds
+	Time     time.Time `json:"time"`               // when the song started playing
+	// failed submissions that counted, see flush
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// how often to try again after a scrobbler failed to take a scrobble
+const scrobbleRetryInterval = time.Minute
+
+// how many times a play may fail, for reasons that don't pass by themselves,
+// before it is dropped so the plays after it can go through
+const maxScrobbleAttempts = 10
+
+// ScrobbleQueue keeps plays on disk until they've been submitted, so plays
+// during an outage aren't lost. They are sent in order, with the time they
+// were played, by a worker that tries again every scrobbleRetryInterval while
+// submitting fails.
+type ScrobbleQueue struct {
+	path   string // "" to keep nothing on disk
+	logger Logger
+	submit func(ctx context.Context, scrobble PendingScrobble) error
+
+	lock    sync.Mutex
+	pending []PendingScrobble
+	// called, without lock held, whenever pending changes
+	onChange func()
+
+	// what stopped the last flush, so it is logged once rather than on every
+	// try. Only the worker uses it.
+	lastError string
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewScrobbleQueue loads the plays left in path by the last run and starts
+// submitting them with submit
+func NewScrobbleQueue(path string, logger Logger, submit func(ctx context.Context, scrobble PendingScrobble) error) *ScrobbleQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &ScrobbleQueue{
+		path:   path,
+		logger: logger,
+		submit: submit,
+		wake:   make(chan struct{}, 1),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if path != "" {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			if err := json.Unmarshal(data, &q.pending); err != nil {
+				logger.Printf("NewScrobbleQueue: reading %s -- %s", path, err.Error())
+			}
+		}
+	}
+
+	go q.run()
+	return q
+}
+
+// Close stops submitting. Whatever is left is sent next time.
+func (q *ScrobbleQueue) Close() {
+	q.cancel()
+}
+
+func (q *ScrobbleQueue) SetOnChange(onChange func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.onChange = onChange
+}
+
+func (q *ScrobbleQueue) changed() {
+	q.lock.Lock()
+	onChange := q.onChange
+	q.lock.Unlock()
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// Add queues a play for submission
+func (q *ScrobbleQueue) Add(scrobble PendingScrobble) {
+	q.lock.Lock()
+	q.pending = append(q.pending, scrobble)
+	q.save()
+	q.lock.Unlock()
+
+	q.Resume()
+	q.changed()
+}
+
+// Len returns how many plays are waiting to be submitted
+func (q *ScrobbleQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.pending)
+}
+
+// Resume has the worker try again now, for when there is a new play or the
+// server is back
+func (q *ScrobbleQueue) Resume() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run submits plays until Close
+func (q *ScrobbleQueue) run() {
+	ticker := time.NewTicker(scrobbleRetryInterval)
+	defer ticker.Stop()
+	for {
+		q.flush()
+		select {
+		case <-q.wake:
+		case <-ticker.C:
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+// flush submits plays, oldest first, until there are none left or submitting
+// fails. A play that is turned down for what it is, see isRejected, is
+// dropped. An outage or an expired session keeps every play for the next try,
+// however long that takes. Any other failure, like a server without a scrobble
+// endpoint, counts against the play, which is dropped after
+// maxScrobbleAttempts so it can't hold up the rest forever.
+func (q *ScrobbleQueue) flush() {
+	for {
+		q.lock.Lock()
+		if len(q.pending) == 0 {
+			q.lock.Unlock()
+			return
+		}
+		next := q.pending[0]
+		q.lock.Unlock()
+
+		err := q.submit(q.ctx, next)
+		if err != nil && !isRejected(err) {
+			quiet := q.ctx.Err() != nil || isTransient(err) || isTemporary(err) || errors.Is(err, ErrOffline)
+			counted := !quiet && !isAuthError(err)
+			if counted {
+				next.Attempts++
+				q.lock.Lock()
+				q.pending[0].Attempts = next.Attempts
+				q.save()
+				q.lock.Unlock()
+			}
+			if !counted || next.Attempts < maxScrobbleAttempts {
+				if !quiet && err.Error() != q.lastError {
+					q.logger.Printf("ScrobbleQueue: keeping %d plays until submitting works -- %s", q.Len(), describeError(err))
+				}
+				q.lastError = err.Error()
+				return
+			}
+		}
+		q.lastError = ""
+		if err != nil {
+			q.logger.Printf("ScrobbleQueue: dropping %s -- %s", next.Id, err.Error())
+		}
+
+		q.lock.Lock()
+		// Add only appends, so next is still first
+		q.pending = q.pending[1:]
+		q.save()
+		q.lock.Unlock()
+		q.changed()
+	}
+}
+
+// isRejected reports whether err says the play itself was turned down, like
+// an unknown song id, rather than the scrobbler being away or refusing us
+func isRejected(err error) bool {
+	if isAuthError(err) {
+		return false
+	}
+	var rejected interface{ Rejected() bool }
+	return errors.As(err, &rejected) && rejected.Rejected()
+}
+
+// isAuthError reports whether err says the scrobbler won't take our
+// credentials, which holds for every play until the user fixes them
+func isAuthError(err error) bool {
+	return errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrUnauthorized)
+}
+
+// isTemporary reports whether err says of itself that it will pass, like
+// net.Error and LastFMError do
+func isTemporary(err error) bool {
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+// save writes the queue. It expects lock to be held.
+func (q *ScrobbleQueue) save() {
+	if q.path == "" {
+		return
+	}
+	data, err := json.Marshal(q.pending)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(q.path), 0755)
+	}
+	if err == nil {
+		// write and rename, so a crash can't leave half a queue
+		err = ioutil.WriteFile(q.path+".tmp", data, 0644)
+		if err == nil {
+			err = os.Rename(q.path+".tmp", q.path)
+		}
+	}
+	if err != nil {
+		q.logger.Printf("ScrobbleQueue: saving %s -- %s", q.path, err.Error())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestFlushKeepsPlaysUnlessRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		// how many of the two plays are left after a flush
+		left int
+	}{
+		{"submitted", nil, 0},
+		{"offline", ErrOffline, 2},
+		{"connection refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, 2},
+		{"Last.fm offline", &LastFMError{Code: 11}, 2},
+		{"Last.fm session expired", &LastFMError{Code: 9}, 2},
+		{"Last.fm API key suspended", &LastFMError{Code: 26}, 2},
+		{"ListenBrainz bad token", &HTTPStatusError{StatusCode: 401}, 2},
+		{"forbidden", &HTTPStatusError{StatusCode: 403}, 2},
+		{"subsonic wrong password", &SubsonicError{Code: 40}, 2},
+		{"subsonic not authorized", &SubsonicError{Code: 50}, 2},
+		{"server error", &HTTPStatusError{StatusCode: 500}, 2},
+		{"subsonic generic error", &SubsonicError{Code: 0}, 2},
+		{"no scrobble endpoint", &HTTPStatusError{StatusCode: 404}, 2},
+		{"subsonic unknown song", &SubsonicError{Code: 70}, 0},
+		{"Last.fm invalid parameters", &LastFMError{Code: 6}, 0},
+		{"ListenBrainz invalid listen", &HTTPStatusError{StatusCode: 400}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			submitted := 0
+			q := &ScrobbleQueue{
+				logger: testLogger(),
+				submit: func(ctx context.Context, scrobble PendingScrobble) error {
+					submitted++
+					return test.err
+				},
+				pending: []PendingScrobble{{Id: "1"}, {Id: "2"}},
+				ctx:     context.Background(),
+			}
+			q.flush()
+			if n := q.Len(); n != test.left {
+				t.Errorf("%d plays left, want %d", n, test.left)
+			}
+			if test.left > 0 && submitted != 1 {
+				t.Errorf("submitted %d times, want flush to stop at the first failure", submitted)
+			}
+		})
+	}
+}
+
+// TestFlushDropsAPlayThatKeepsFailing has the first play fail every time; the
+// second still gets through, unless the failure will pass
+func TestFlushDropsAPlayThatKeepsFailing(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		// whether the second play is submitted
+		submitted bool
+	}{
+		{"subsonic generic error", &SubsonicError{Code: 0}, true},
+		{"no scrobble endpoint", &HTTPStatusError{StatusCode: 404}, true},
+		{"offline", ErrOffline, false},
+		{"server error", &HTTPStatusError{StatusCode: 500}, false},
+		{"Last.fm session expired", &LastFMError{Code: 9}, false},
+		{"subsonic wrong password", &SubsonicError{Code: 40}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var submitted []string
+			q := &ScrobbleQueue{
+				logger: testLogger(),
+				submit: func(ctx context.Context, scrobble PendingScrobble) error {
+					if scrobble.Id == "1" {
+						return test.err
+					}
+					submitted = append(submitted, scrobble.Id)
+					return nil
+				},
+				pending: []PendingScrobble{{Id: "1"}, {Id: "2"}},
+				ctx:     context.Background(),
+			}
+			for i := 0; i < maxScrobbleAttempts; i++ {
+				if len(submitted) > 0 {
+					t.Fatalf("%s submitted after %d tries, want %d", submitted, i, maxScrobbleAttempts)
+				}
+				q.flush()
+			}
+			if got := len(submitted) > 0; got != test.submitted {
+				t.Errorf("second play submitted %v, want %v", got, test.submitted)
+			}
+			if test.submitted && q.Len() != 0 {
+				t.Errorf("%d plays left, want none", q.Len())
+			}
+		})
+	}
+}
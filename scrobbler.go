@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Scrobbler is somewhere plays are reported to: the server, which may pass
+// them on, or a service like ListenBrainz directly
+type Scrobbler interface {
+	// NowPlaying says track just started
+	NowPlaying(ctx context.Context, track QueueItem) error
+	// Submit reports a play. It is called through a ScrobbleQueue, which
+	// tries again later if the error is transient.
+	Submit(ctx context.Context, scrobble PendingScrobble) error
+}
+
+// ScrobbleTarget is a Scrobbler along with the plays it has yet to take
+type ScrobbleTarget struct {
+	Name      string
+	Scrobbler Scrobbler
+	Queue     *ScrobbleQueue
+}
+
+// NewScrobbleTarget starts a queue for scrobbler, kept in queuePath
+func NewScrobbleTarget(name string, scrobbler Scrobbler, queuePath string, logger Logger) *ScrobbleTarget {
+	return &ScrobbleTarget{
+		Name:      name,
+		Scrobbler: scrobbler,
+		Queue:     NewScrobbleQueue(queuePath, logger, scrobbler.Submit),
+	}
+}
+
+// subsonicScrobbler scrobbles through the server's scrobble endpoint
+type subsonicScrobbler struct {
+	connection *SubsonicConnection
+}
+
+func (s subsonicScrobbler) NowPlaying(ctx context.Context, track QueueItem) error {
+	_, err := s.connection.ScrobbleSubmission(ctx, track.Id, false)
+	return err
+}
+
+func (s subsonicScrobbler) Submit(ctx context.Context, scrobble PendingScrobble) error {
+	return s.connection.SubmitScrobble(ctx, scrobble.Id, scrobble.Time)
+}
+
+// newScrobbleTargets sets up the services configured under [scrobble], which
+// are told about plays whatever the server does with them
+func newScrobbleTargets(logger Logger) ([]*ScrobbleTarget, error) {
+	var targets []*ScrobbleTarget
+	queuePath := func(name string) string {
+		if dir := cacheDirectory(); dir != "" {
+			return filepath.Join(dir, name+"-scrobbles.json")
+		}
+		return ""
+	}
+	client := NewHTTPClient(viper.GetDuration("server.timeout"), viper.GetBool("server.keepAlive"))
+
+	if token := viper.GetString("scrobble.listenbrainz.token"); token != "" {
+		listenBrainz := &ListenBrainz{
+			Url:    viper.GetString("scrobble.listenbrainz.url"),
+			Token:  token,
+			Client: client,
+		}
+		targets = append(targets, NewScrobbleTarget("ListenBrainz", listenBrainz, queuePath("listenbrainz"), logger))
+	}
+
+	if apiKey := viper.GetString("scrobble.lastfm.apiKey"); apiKey != "" {
+		lastFM := &LastFM{
+			Url:        viper.GetString("scrobble.lastfm.url"),
+			ApiKey:     apiKey,
+			Secret:     viper.GetString("scrobble.lastfm.secret"),
+			SessionKey: viper.GetString("scrobble.lastfm.sessionKey"),
+			Client:     client,
+		}
+		if lastFM.Secret == "" || lastFM.SessionKey == "" {
+			for _, target := range targets {
+				target.Queue.Close()
+			}
+			return nil, fmt.Errorf("scrobble.lastfm needs secret and sessionKey as well as apiKey, run stmp --lastfm-login to get a session key")
+		}
+		targets = append(targets, NewScrobbleTarget("Last.fm", lastFM, queuePath("lastfm"), logger))
+	}
+	return targets, nil
+}
+
+// runScrobbler reports plays to targets: "now playing" when a track starts,
+// and a submission once it has been listened to for long enough, see
+// playTracker. Submissions go through each target's queue, so none are lost
+// while a service is away, and "now playing" is sent by each target's
+// sendNowPlaying, so a slow service doesn't hold up the events. It returns
+// when the player shuts down or ctx is done.
+func runScrobbler(ctx context.Context, player *Player, logger Logger, targets []*ScrobbleTarget) {
+	events, unsubscribe := player.Subscribe()
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	nowPlaying := make([]chan QueueItem, len(targets))
+	for i, target := range targets {
+		nowPlaying[i] = make(chan QueueItem, 1)
+		go sendNowPlaying(ctx, logger, target, nowPlaying[i])
+	}
+
+	var tracker *playTracker
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			switch event.Type {
+			case EventTrackStarted:
+				tracker = nil
+				if event.Track == nil {
+					continue
+				}
+				tracker = newPlayTracker(*event.Track, time.Now())
+				for _, tracks := range nowPlaying {
+					// a track still waiting has been skipped already, so this
+					// one takes its place
+					select {
+					case <-tracks:
+					default:
+					}
+					tracks <- *event.Track
+				}
+
+			case EventTrackEnded:
+				tracker = nil
+
+			case EventSeeked:
+				if tracker != nil {
+					tracker.seeked(event.Position, time.Now())
+				}
+
+			case EventPositionChanged:
+				if tracker != nil && tracker.progressed(event.Position, event.Duration, time.Now()) {
+					logger.Printf("scrobbler: submitting %s, listened to for %.0fs", tracker.track.Title, tracker.listened)
+					scrobble := tracker.scrobble()
+					for _, target := range targets {
+						target.Queue.Add(scrobble)
+					}
+				}
+			}
+		}
+	}
+}
+
+// sendNowPlaying tells target about each track in tracks until ctx is done
+func sendNowPlaying(ctx context.Context, logger Logger, target *ScrobbleTarget, tracks <-chan QueueItem) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case track := <-tracks:
+			if err := target.Scrobbler.NowPlaying(ctx, track); err != nil && ctx.Err() == nil {
+				logger.Printf("scrobbler: %s: now playing -- %s", target.Name, err.Error())
+			}
+		}
+	}
+}
+
+// how far the position may move beyond the time that passed between two
+// reports and still count as listened to, since reports are late or early
+const positionSlack = 1.0
+
+// playTracker adds up how long a track has actually been listened to, from
+// the positions the player reports as it plays. Pauses don't count, and
+// neither do seeks: only steps forward that fit in the time that passed since
+// the last report.
+type playTracker struct {
+	track     QueueItem
+	startedAt time.Time
+	duration  float64 // in seconds, the track's or else the player's
+	listened  float64
+	position  float64
+	lastSeen  time.Time
+	submitted bool
+}
+
+func newPlayTracker(track QueueItem, now time.Time) *playTracker {
+	return &playTracker{
+		track:     track,
+		startedAt: now,
+		duration:  float64(track.Duration),
+		lastSeen:  now,
+	}
+}
+
+// seeked moves the position without counting the jump
+func (t *playTracker) seeked(position float64, now time.Time) {
+	t.position, t.lastSeen = position, now
+}
+
+// progressed notes the position, and the duration if the track had none. It
+// returns true, once, when the track has been listened to long enough to be
+// scrobbled.
+func (t *playTracker) progressed(position, duration float64, now time.Time) bool {
+	if t.duration <= 0 {
+		t.duration = duration
+	}
+	step := position - t.position
+	if step > 0 && step <= now.Sub(t.lastSeen).Seconds()+positionSlack {
+		t.listened += step
+	}
+	t.position, t.lastSeen = position, now
+
+	if t.submitted || !t.due() {
+		return false
+	}
+	t.submitted = true
+	return true
+}
+
+// due applies Last.fm's rule, see https://www.last.fm/api/scrobbling: a track
+// longer than 30 seconds is scrobbled once it has been played for half its
+// duration or for 4 minutes, whichever comes first
+func (t *playTracker) due() bool {
+	if t.duration <= 30 {
+		return false
+	}
+	return t.listened >= math.Min(t.duration/2, 240)
+}
+
+func (t *playTracker) scrobble() PendingScrobble {
+	return PendingScrobble{
+		Id:       t.track.Id,
+		Title:    t.track.Title,
+		Artist:   t.track.Artist,
+		Album:    t.track.Album,
+		Duration: int(t.duration),
+		Time:     t.startedAt,
+	}
+}
+
+// record is the play for the listening history, ended at ended
+func (t *playTracker) record(ended time.Time, skipped bool) PlayRecord {
+	percent := 0.0
+	if t.duration > 0 {
+		percent = math.Min(t.listened/t.duration*100, 100)
+	}
+	return PlayRecord{
+		Id:       t.track.Id,
+		Title:    t.track.Title,
+		Artist:   t.track.Artist,
+		Album:    t.track.Album,
+		Started:  t.startedAt,
+		Ended:    ended,
+		Duration: int(t.duration),
+		Listened: t.listened,
+		Percent:  percent,
+		Skipped:  skipped,
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testScrobbler hands each now playing to nowPlaying, and blocks there if
+// nobody takes it
+type testScrobbler struct {
+	nowPlaying chan string
+}
+
+func (s testScrobbler) NowPlaying(ctx context.Context, track QueueItem) error {
+	select {
+	case s.nowPlaying <- track.Id:
+	case <-ctx.Done():
+	}
+	return ctx.Err()
+}
+
+func (s testScrobbler) Submit(ctx context.Context, scrobble PendingScrobble) error {
+	return nil
+}
+
+// TestSlowNowPlayingHoldsNothingUp has one service hang on "now playing";
+// the other still hears about every track
+func TestSlowNowPlayingHoldsNothingUp(t *testing.T) {
+	var player Player
+	stuck := testScrobbler{make(chan string)}
+	working := testScrobbler{make(chan string)}
+	targets := []*ScrobbleTarget{
+		{Name: "stuck", Scrobbler: stuck, Queue: NewScrobbleQueue("", testLogger(), stuck.Submit)},
+		{Name: "working", Scrobbler: working, Queue: NewScrobbleQueue("", testLogger(), working.Submit)},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runScrobbler(ctx, &player, testLogger(), targets)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		for _, target := range targets {
+			target.Queue.Close()
+		}
+	}()
+
+	// wait for runScrobbler to subscribe
+	for subscribed := false; !subscribed; time.Sleep(time.Millisecond) {
+		player.events.lock.Lock()
+		subscribed = len(player.events.subscribers) > 0
+		player.events.lock.Unlock()
+	}
+
+	for _, id := range []string{"0", "1", "2"} {
+		player.publish(PlayerEvent{Type: EventTrackStarted, Track: &QueueItem{Id: id}})
+		select {
+		case got := <-working.nowPlaying:
+			if got != id {
+				t.Fatalf("now playing %s, want %s", got, id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("track %s: no now playing while another service hangs", id)
+		}
+	}
+}
+
+// trackerStep is something the player reports, at seconds into the test
+type trackerStep struct {
+	at       float64
+	kind     string // "position", "seek" or "start", the track starting over
+	position float64
+}
+
+func TestPlayTracker(t *testing.T) {
+	// steps plays from from to to, reporting the position every 10 seconds
+	steps := func(from, to float64) []trackerStep {
+		var played []trackerStep
+		for at := from + 10; at <= to; at += 10 {
+			played = append(played, trackerStep{at, "position", at})
+		}
+		return played
+	}
+	join := func(runs ...[]trackerStep) []trackerStep {
+		var joined []trackerStep
+		for _, run := range runs {
+			joined = append(joined, run...)
+		}
+		return joined
+	}
+
+	tests := []struct {
+		name           string
+		duration       int     // the track's, 0 to take the player's
+		playerDuration float64 // what the player reports
+		steps          []trackerStep
+		// each scrobble as "at <position>, started <seconds>"
+		scrobbles []string
+		listened  float64
+	}{
+		{
+			name:      "half of the track",
+			duration:  100,
+			steps:     steps(0, 100),
+			scrobbles: []string{"at 50, started 0"},
+			listened:  100,
+		},
+		{
+			name:     "just short of half",
+			duration: 100,
+			steps:    join(steps(0, 40), []trackerStep{{49, "position", 49}}),
+			listened: 49,
+		},
+		{
+			name:      "4 minutes of a long track",
+			duration:  600,
+			steps:     steps(0, 300),
+			scrobbles: []string{"at 240, started 0"},
+			listened:  300,
+		},
+		{
+			name:     "30 seconds is too short",
+			duration: 30,
+			steps:    steps(0, 30),
+			listened: 30,
+		},
+		{
+			name:      "31 seconds is long enough",
+			duration:  31,
+			steps:     []trackerStep{{10, "position", 10}, {16, "position", 16}, {31, "position", 31}},
+			scrobbles: []string{"at 16, started 0"},
+			listened:  31,
+		},
+		{
+			name:           "duration from the player",
+			playerDuration: 100,
+			steps:          steps(0, 60),
+			scrobbles:      []string{"at 50, started 0"},
+			listened:       60,
+		},
+		{
+			name:     "seeking forward doesn't count",
+			duration: 100,
+			steps: join(
+				steps(0, 20),
+				[]trackerStep{{21, "seek", 80}, {30, "position", 89}, {40, "position", 99}},
+			),
+			listened: 39,
+		},
+		{
+			name:     "a jump the player didn't call a seek doesn't count",
+			duration: 100,
+			steps:    []trackerStep{{10, "position", 10}, {11, "position", 70}, {20, "position", 79}},
+			listened: 19,
+		},
+		{
+			name:     "seeking back and listening again counts",
+			duration: 100,
+			steps: join(
+				steps(0, 40),
+				[]trackerStep{{40, "seek", 0}, {50, "position", 10}},
+			),
+			scrobbles: []string{"at 10, started 0"},
+			listened:  50,
+		},
+		{
+			name:     "time paused doesn't count",
+			duration: 100,
+			steps: []trackerStep{
+				{10, "position", 10}, {20, "position", 20},
+				// paused for three minutes
+				{200, "position", 21}, {210, "position", 31}, {220, "position", 41},
+			},
+			listened: 41,
+		},
+		{
+			name:     "paused, then played to half",
+			duration: 100,
+			steps: []trackerStep{
+				{10, "position", 10}, {20, "position", 20},
+				{200, "position", 30}, {210, "position", 40}, {220, "position", 50},
+			},
+			scrobbles: []string{"at 50, started 0"},
+			listened:  50,
+		},
+		{
+			name:     "only scrobbled once per play",
+			duration: 60,
+			steps: join(
+				steps(0, 60),
+				[]trackerStep{{60, "seek", 0}},
+				[]trackerStep{{70, "position", 10}, {80, "position", 20}, {90, "position", 30}},
+			),
+			scrobbles: []string{"at 30, started 0"},
+			listened:  90,
+		},
+		{
+			name:     "replayed right after itself",
+			duration: 60,
+			steps: join(
+				steps(0, 60),
+				[]trackerStep{{60, "start", 0}},
+				[]trackerStep{{70, "position", 10}, {80, "position", 20}, {90, "position", 30}},
+			),
+			scrobbles: []string{"at 30, started 0", "at 30, started 60"},
+			listened:  30,
+		},
+	}
+
+	begin := time.Unix(1600000000, 0)
+	at := func(seconds float64) time.Time {
+		return begin.Add(time.Duration(seconds * float64(time.Second)))
+	}
+	track := QueueItem{Id: "1", Title: "Song"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			track.Duration = test.duration
+			tracker := newPlayTracker(track, begin)
+			var scrobbles []string
+			for _, step := range test.steps {
+				switch step.kind {
+				case "start":
+					tracker = newPlayTracker(track, at(step.at))
+				case "seek":
+					tracker.seeked(step.position, at(step.at))
+				case "position":
+					if tracker.progressed(step.position, test.playerDuration, at(step.at)) {
+						scrobble := tracker.scrobble()
+						scrobbles = append(scrobbles, fmt.Sprintf("at %g, started %g", step.position, scrobble.Time.Sub(begin).Seconds()))
+					}
+				}
+			}
+			if !reflect.DeepEqual(scrobbles, test.scrobbles) {
+				t.Errorf("scrobbled %q, want %q", scrobbles, test.scrobbles)
+			}
+			if tracker.listened != test.listened {
+				t.Errorf("listened to %gs, want %gs", tracker.listened, test.listened)
+			}
+		})
+	}
+}
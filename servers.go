@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// createServersPage lists the server profiles. Selecting one connects to it
+// in place of the current server.
+func (ui *Ui) createServersPage(titleFlex *tview.Flex) *tview.Flex {
+	ui.profileList = tview.NewList().ShowSecondaryText(false)
+	ui.profileList.SetBorder(true).SetTitle("Servers")
+	ui.updateProfileList()
+
+	ui.profileList.SetSelectedFunc(func(index int, _ string, _ string, _ rune) {
+		names := profileNames()
+		if index < len(names) {
+			ui.switchProfile(names[index])
+		}
+	})
+	ui.profileList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if keyName(event) == keybind("refresh") {
+			ui.updateProfileList()
+			return nil
+		}
+		return event
+	})
+
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(titleFlex, 1, 0, false).
+		AddItem(ui.profileList, 0, 1, true)
+}
+
+func (ui *Ui) updateProfileList() {
+	current := ui.profileList.GetCurrentItem()
+	ui.profileList.Clear()
+
+	names := profileNames()
+	if len(names) == 0 {
+		ui.profileList.AddItem("[::d]no profiles, add [profiles.<name>] to stmp.toml", "", 0, nil)
+		return
+	}
+	for _, name := range names {
+		text := "  " + tview.Escape(name) + " [::d]" + tview.Escape(profileString(name, "server.host"))
+		if name == ui.profile {
+			text = "[::b]* " + tview.Escape(name) + "[::-] [::d]" + tview.Escape(profileString(name, "server.host"))
+		}
+		ui.profileList.AddItem(text, "", 0, nil)
+	}
+	if current < len(names) {
+		ui.profileList.SetCurrentItem(current)
+	}
+}
+
+// switchProfile connects to the server of profile name and, once that worked,
+// swaps it in for the current one. The queue is cleared, its songs belong to
+// the old server.
+func (ui *Ui) switchProfile(name string) {
+	if name == ui.profile {
+		return
+	}
+	ui.connection.Logger.Printf("switchProfile: connecting to %s", name)
+
+	logger := ui.connection.Logger
+	ui.runInBackground(ui.ctx, func(ctx context.Context) func() {
+		// the terminal belongs to the UI, so no password prompt
+		connection, err := connectProfile(ctx, name, logger, false)
+		if err != nil {
+			return func() { logger.PrintError(err, "switchProfile: %s", name) }
+		}
+		indexResponse, err := connection.GetIndexes(ctx)
+		if err != nil {
+			connection.Close()
+			return func() { logger.PrintError(err, "switchProfile: %s: GetIndexes", name) }
+		}
+		playlistResponse, err := connection.GetPlaylists(ctx)
+		if err != nil {
+			connection.Close()
+			return func() { logger.PrintError(err, "switchProfile: %s: GetPlaylists", name) }
+		}
+		// offline there are no stars, but everything else works
+		starred, folders, err := fetchStarred(ctx, connection)
+		if err != nil && !errors.Is(err, ErrOffline) {
+			connection.Close()
+			return func() { logger.PrintError(err, "switchProfile: %s", name) }
+		}
+
+		return func() {
+			ui.useConnection(name, connection, indexResponse.Indexes.Index,
+				playlistResponse.Playlists.Playlists, starred, folders)
+		}
+	})
+}
+
+// useConnection replaces the connection and everything fetched through it
+func (ui *Ui) useConnection(profile string, connection *SubsonicConnection, indexes []SubsonicIndex, playlists []SubsonicPlaylist, starred SubsonicStarred, folders SubsonicStarredFolders) {
+	if ui.browseCancel != nil {
+		ui.browseCancel()
+	}
+	if ui.coverArtCancel != nil {
+		ui.coverArtCancel()
+	}
+
+	ui.player.ReplaceQueue(nil)
+	if err := ui.player.Stop(); err != nil {
+		connection.Logger.Printf("useConnection: Stop -- %s", err.Error())
+	}
+	if err := ui.player.SetStreamHeaders(connection.StreamHeaders()); err != nil {
+		connection.Logger.PrintError(err, "useConnection: SetStreamHeaders")
+	}
+
+	ui.connection.Close()
+	ui.profile = profile
+	ui.connection = connection
+	ui.followDownloads()
+	ui.startScrobbler()
+	ui.followOffline()
+
+	ui.starIdList = map[string]struct{}{}
+	ui.starredFolders = map[string]struct{}{}
+	ui.ratings = map[string]int{}
+	ui.artistInfoCache = map[string]SubsonicArtistInfo{}
+	ui.currentDirectory = nil
+	ui.entityList.Clear()
+	ui.selectedPlaylist.Clear()
+	ui.currentPlaylistIndex = 0
+
+	ui.noteStarred(starred, folders)
+	ui.setIndexes(indexes)
+	ui.setPlaylists(playlists)
+	ui.updateStarredLists()
+	updateQueueList(ui.player, ui.queueList, ui.starIdList, ui.ratings)
+	ui.updateProfileList()
+	ui.updateDownloadsList()
+
+	connection.Logger.Printf("useConnection: connected to %s", profile)
+	ui.pages.SwitchToPage("browser")
+	ui.currentPage.SetText("Browser")
+	ui.app.SetFocus(ui.artistList)
+}
+
+// startScrobbler (re)starts scrobbling to the current server, if it wants
+// scrobbles, and to the services configured under [scrobble]
+func (ui *Ui) startScrobbler() {
+	if ui.scrobblerCancel != nil {
+		ui.scrobblerCancel()
+		ui.scrobblerCancel = nil
+	}
+	ui.followScrobbles()
+	targets := ui.scrobbleTargets()
+	if len(targets) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ui.ctx)
+	ui.scrobblerCancel = cancel
+	go runScrobbler(ctx, ui.player, ui.connection.Logger, targets)
+}
+
+// scrobbleTargets lists where plays go: the server, if it wants them, and the
+// services configured under [scrobble]
+func (ui *Ui) scrobbleTargets() []*ScrobbleTarget {
+	var targets []*ScrobbleTarget
+	if ui.connection.Scrobbles != nil {
+		targets = append(targets, &ScrobbleTarget{
+			Name:      "server",
+			Scrobbler: subsonicScrobbler{ui.connection},
+			Queue:     ui.connection.Scrobbles,
+		})
+	}
+	return append(targets, ui.scrobblers...)
+}
+
+// followScrobbles keeps the number of scrobbles waiting for the server on the
+// log page
+func (ui *Ui) followScrobbles() {
+	for _, target := range ui.scrobbleTargets() {
+		queue := target.Queue
+		queue.SetOnChange(func() {
+			ui.app.QueueUpdateDraw(func() {
+				// the connection may have changed since
+				for _, target := range ui.scrobbleTargets() {
+					if target.Queue == queue {
+						ui.updateScrobbleStatus()
+						return
+					}
+				}
+			})
+		})
+	}
+	ui.updateScrobbleStatus()
+}
+
+func (ui *Ui) updateScrobbleStatus() {
+	var counts []string
+	for _, target := range ui.scrobbleTargets() {
+		if pending := target.Queue.Len(); pending > 0 {
+			counts = append(counts, fmt.Sprintf("%s %d", target.Name, pending))
+		}
+	}
+	if len(counts) == 0 {
+		ui.scrobbleStatus.SetText("[::d]no scrobbles pending")
+		return
+	}
+	ui.scrobbleStatus.SetText("[yellow]scrobbles pending: " + tview.Escape(strings.Join(counts, ", ")))
+}
+
+// followOffline shows whether the current connection is offline, and brings
+// the library up to date when the server is back
+func (ui *Ui) followOffline() {
+	connection := ui.connection
+	connection.SetOnOfflineChange(func(offline bool) {
+		ui.app.QueueUpdateDraw(func() {
+			// the connection may have changed since
+			if ui.connection != connection {
+				return
+			}
+			ui.showOffline(offline)
+			if !offline {
+				ui.refreshLibrary()
+			}
+		})
+	})
+	ui.showOffline(connection.Offline())
+}
+
+// showOffline updates the offline marker and greys out, or no longer greys
+// out, what can't be played offline
+func (ui *Ui) showOffline(offline bool) {
+	if offline {
+		ui.offlineStatus.SetText("[red::b]offline")
+	} else {
+		ui.offlineStatus.SetText("")
+	}
+	ui.redrawLists()
+}
+
+// redrawLists brings the rows of the artist, entity, playlist and starred
+// song lists up to date with what is starred, rated and playable
+func (ui *Ui) redrawLists() {
+	for i := range ui.artistIdList {
+		ui.artistList.SetItemText(i, ui.artistTextFormat(i), "")
+	}
+	// the lists may be loading, or have failed to
+	if ui.currentDirectory != nil {
+		offset := 0
+		if ui.currentDirectory.Parent != "" {
+			offset = 1
+		}
+		if len(ui.currentDirectory.Entities)+offset == ui.entityList.GetItemCount() {
+			for i, entity := range ui.currentDirectory.Entities {
+				updateEntityListItem(ui.entityList, i+offset, ui.entityTextFormat(entity))
+			}
+		}
+	}
+	if ui.currentPlaylistIndex < len(ui.playlists) {
+		entries := ui.playlists[ui.currentPlaylistIndex].Entries
+		if len(entries) == ui.selectedPlaylist.GetItemCount() {
+			for i, entity := range entries {
+				ui.selectedPlaylist.SetItemText(i, ui.offlineTextFormat(entity.getSongTitle(), entity.Id, false), "")
+			}
+		}
+	}
+	if len(ui.starred.Songs) == ui.starredSongList.GetItemCount() {
+		for i, entity := range ui.starred.Songs {
+			ui.starredSongList.SetItemText(i, ui.entityTextFormat(entity), "")
+		}
+	}
+}
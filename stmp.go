@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
  	"log"
     "time"
 
@@ -14,7 +16,7 @@ import (
 )
 
 func readConfig() {
-	required_properties := []string{"auth.username", "auth.password", "server.host"}
+	required_properties := []string{"server.host"}
 
 	viper.SetConfigName("stmp")
 	viper.SetConfigType("toml")
@@ -28,19 +30,40 @@ func readConfig() {
 	viper.SetDefault("keys.refresh", "r")
 	viper.SetDefault("keys.add", "a")
 	viper.SetDefault("keys.star", "y")
+	viper.SetDefault("keys.rate", "R")
+	viper.SetDefault("keys.rateCurrent", "Ctrl+R")
 	viper.SetDefault("keys.newPlaylist", "a")
 	viper.SetDefault("keys.addToPlaylist", "A")
+	viper.SetDefault("keys.addCurrentToPlaylist", "Ctrl+A")
 	viper.SetDefault("keys.deletePlaylist", "d")
+	viper.SetDefault("keys.removeFromPlaylist", "d")
+	viper.SetDefault("keys.moveUp", "K")
+	viper.SetDefault("keys.moveDown", "J")
+	viper.SetDefault("keys.editPlaylist", "e")
+	viper.SetDefault("keys.togglePublic", "P")
+	viper.SetDefault("keys.saveQueue", "S")
 	viper.SetDefault("keys.removeFromQueue", "d")
 	viper.SetDefault("keys.pageBrowser", "1")
 	viper.SetDefault("keys.pageQueue", "2")
 	viper.SetDefault("keys.pagePlaylists", "3")
+	viper.SetDefault("keys.pageStarred", "4")
+	viper.SetDefault("keys.pageNowPlaying", "5")
+	viper.SetDefault("keys.pageLyrics", "0")
 	viper.SetDefault("keys.playprevtrack", "6")
 	viper.SetDefault("keys.pageLog", "7")
 	viper.SetDefault("keys.playnexttrack", "8")
 	viper.SetDefault("keys.nextPlaylist", "9") 
 	viper.SetDefault("keys.quit", "q")
+	viper.SetDefault("keys.pageServers", "Ctrl+P")
+	viper.SetDefault("keys.pageDownloads", "Ctrl+D")
+	viper.SetDefault("keys.pageHistory", "Ctrl+Y")
+	viper.SetDefault("keys.pageStats", "Ctrl+T")
+	viper.SetDefault("keys.download", "o")
+	viper.SetDefault("keys.removeDownload", "d")
 	viper.SetDefault("keys.addRandomSongs", "s")
+	viper.SetDefault("keys.addAllStarred", "A")
+	viper.SetDefault("keys.artistInfo", "i")
+	viper.SetDefault("keys.playTopSongs", "t")
 	viper.SetDefault("keys.clearQueue", "D")
 	viper.SetDefault("keys.playPause", "p")
 	viper.SetDefault("keys.volumeDown", "-")
@@ -52,6 +75,37 @@ func readConfig() {
 	viper.SetDefault("keys.left", "Left")
 	viper.SetDefault("keys.right", "Right")
 
+	// Cover art: auto, kitty, sixel, halfblock or none. The cell size, in
+	// pixels, is only used to size sixel images.
+	viper.SetDefault("ui.coverArt", "auto")
+	viper.SetDefault("ui.cellWidth", 10)
+	viper.SetDefault("ui.cellHeight", 20)
+
+	// Network: timeout for a whole request, how often failed reads are
+	// retried, whether connections are reused between requests, and how
+	// often to look for the server while offline
+	viper.SetDefault("server.timeout", "30s")
+	viper.SetDefault("server.retries", 2)
+	viper.SetDefault("server.keepAlive", true)
+	viper.SetDefault("server.reconnectInterval", "30s")
+	// how old stored directories and albums may get before they are fetched
+	// again, in the background
+	viper.SetDefault("cache.maxAge", "1h")
+	// send stream credentials as basic auth, for a reverse proxy in front of
+	// the server that checks it; servers themselves want them in the url
+	viper.SetDefault("server.proxyBasicAuth", false)
+
+	// Playback: libmpv, or ipc (an mpv process spoken to over its JSON IPC
+	// socket). Empty picks libmpv, or ipc when built without it.
+	viper.SetDefault("player.backend", "")
+	viper.SetDefault("player.mpvPath", "mpv")
+
+	// Downloads for offline listening, in MB. 0 means no limit.
+	viper.SetDefault("downloads.maxSize", 10240)
+
+	// every play, for the history and stats pages
+	viper.SetDefault("history.file", historyPath())
+
 	err := viper.ReadInConfig()
 
 	if err != nil {
@@ -59,21 +113,53 @@ func readConfig() {
 		os.Exit(1)
 	}
 
+	// profiles may have these instead, connectProfile finds out
+	if len(profileNames()) > 0 {
+		return
+	}
 	for _, prop := range required_properties {
 		if !viper.IsSet(prop) {
 			fmt.Printf("Config property %s is required\n", prop)
 		}
 	}
+	if !viper.IsSet("auth.apiKey") && !viper.IsSet("auth.username") {
+		fmt.Println("Config property auth.username or auth.apiKey is required")
+	}
+}
+
+// cacheDirectory is where cover art and other downloaded data is kept
+func cacheDirectory() string {
+	if dir := viper.GetString("cache.directory"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "stmp")
 }
 
 type Logger struct {
 	prints chan string
+	toasts chan string
 }
 
 func (l Logger) Printf(s string, as ...interface{}) {
 	l.prints <- fmt.Sprintf(s, as...)
 }
 
+// PrintError logs err after the formatted context, and flashes a short
+// description of it on the status line. Use it for errors the user caused and
+// should know about; background noise belongs in Printf.
+func (l Logger) PrintError(err error, s string, as ...interface{}) {
+	l.prints <- fmt.Sprintf(s, as...) + " -- " + err.Error()
+	select {
+	case l.toasts <- describeError(err):
+	default:
+		// a toast is already waiting, the log has this one anyway
+	}
+}
+
 func ListenForButton(player *Player) {
     if _, err := host.Init(); err != nil {
         log.Fatal(err)
@@ -102,6 +188,8 @@ func ListenForButton(player *Player) {
 func main() {
 	help := flag.Bool("help", false, "Print usage")
 	enableMpris := flag.Bool("mpris", false, "Enable MPRIS2")
+	profile := flag.String("profile", "", "Connect to the named server profile")
+	lastFMLogin := flag.Bool("lastfm-login", false, "Get a Last.fm session key for scrobble.lastfm.sessionKey")
 	flag.Parse()
 	if *help {
 		fmt.Printf("USAGE: %s <args>\n", os.Args[0])
@@ -111,34 +199,56 @@ func main() {
 
 	readConfig()
 
-	logger := Logger{make(chan string, 100)}
+	if *lastFMLogin {
+		loginLastFM()
+		os.Exit(0)
+	}
+
+	logger := Logger{make(chan string, 100), make(chan string, 1)}
 
-	connection := &SubsonicConnection{
-		Username:       viper.GetString("auth.username"),
-		Password:       viper.GetString("auth.password"),
-		Host:           viper.GetString("server.host"),
-		PlaintextAuth:  viper.GetBool("auth.plaintext"),
-		Scrobble:       viper.GetBool("server.scrobble"),
-		Logger:         logger,
-		directoryCache: make(map[string]SubsonicResponse),
+	if *profile == "" {
+		*profile = defaultProfile()
 	}
 
-	indexResponse, err := connection.GetIndexes()
+	ctx := context.Background()
+
+	connection, err := connectProfile(ctx, *profile, logger, true)
 	if err != nil {
-		fmt.Printf("Error fetching indexes from server: %s\n", err)
+		fmt.Printf("Error connecting to server: %s\n", describeError(err))
 		os.Exit(1)
 	}
-	playlistResponse, err := connection.GetPlaylists()
-	if err != nil {
-		fmt.Printf("Error fetching playlists from server: %s\n", err)
-		os.Exit(1)
+
+	// start with the library from last time if there is one, the UI brings
+	// it up to date in the background
+	indexResponse, haveIndexes := connection.CachedIndexes()
+	if !haveIndexes {
+		indexResponse, err = connection.GetIndexes(ctx)
+		if err != nil {
+			fmt.Printf("Error fetching indexes from server: %s\n", describeError(err))
+			os.Exit(1)
+		}
+	}
+	playlistResponse, havePlaylists := connection.CachedPlaylists()
+	if !havePlaylists {
+		playlistResponse, err = connection.GetPlaylists(ctx)
+		if err != nil {
+			fmt.Printf("Error fetching playlists from server: %s\n", describeError(err))
+			os.Exit(1)
+		}
 	}
 
-	player, err := InitPlayer()
+	backend, err := newBackend(viper.GetString("player.backend"), viper.GetString("player.mpvPath"))
 	if err != nil {
-		fmt.Println("Unable to initialize mpv. Is mpv installed?")
+		fmt.Printf("Unable to initialize mpv. Is mpv installed? %s\n", err)
 		os.Exit(1)
 	}
+	player := NewPlayer(backend, logger)
+	if headers := connection.StreamHeaders(); len(headers) > 0 {
+		if err := player.SetStreamHeaders(headers); err != nil {
+			fmt.Printf("Unable to pass stream credentials to mpv: %s\n", err)
+			os.Exit(1)
+		}
+	}
 
 	 ListenForButton(player)
 
@@ -153,7 +263,19 @@ func main() {
 		defer mpris.Close()
 	}
 
-	InitGui(&indexResponse.Indexes.Index, &playlistResponse.Playlists.Playlists, connection, player)
+	if hooks := viper.GetStringMapString("hooks"); len(hooks) > 0 {
+		go runHooks(player, hooks, logger)
+	}
+
+	scrobblers, err := newScrobbleTargets(logger)
+	if err != nil {
+		fmt.Printf("Unable to set up scrobbling: %s\n", err)
+		os.Exit(1)
+	}
+
+	history := OpenHistory(viper.GetString("history.file"), logger)
+
+	InitGui(&indexResponse.Indexes.Index, &playlistResponse.Playlists.Playlists, connection, player, *profile, scrobblers, history)
 	
 	
 }